@@ -0,0 +1,36 @@
+package cuddle
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"sync"
+)
+
+var authToken = struct {
+	sync.Mutex
+	token string
+}{}
+
+// Require requests to carry the bearer token in an Authorization header.
+// An empty token allows all requests.
+func SetAuthToken(token string) {
+	authToken.Lock()
+	authToken.token = token
+	authToken.Unlock()
+}
+
+// Middleware rejecting requests without the bearer token.
+func authorize(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	authToken.Lock()
+	token := authToken.token
+	authToken.Unlock()
+
+	if token == "" || subtle.ConstantTimeCompare(
+		[]byte(req.Header.Get("Authorization")), []byte("Bearer "+token)) == 1 {
+		next(w, req)
+		return
+	}
+
+	w.Header().Set("WWW-Authenticate", `Bearer realm="cuddled"`)
+	writeError(w, UnauthorizedError)
+}
@@ -0,0 +1,534 @@
+package cuddle
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"../msgtype"
+	"../trajectory"
+)
+
+// A behaviour preset, read from a TOML file: a trajectory for each of
+// several actuators, with positions given at full intensity and times at
+// the default rate.
+type Behaviour struct {
+	Name        string              `toml:"name" json:"name"`
+	Description string              `toml:"description" json:"description,omitempty"`
+	Intensity   float64             `toml:"intensity" json:"intensity"` // default intensity, 0..1
+	Rate        float64             `toml:"rate" json:"rate"`           // default rate in cycles per minute, or 0 if not adjustable
+	Duration    uint32              `toml:"duration" json:"duration"`   // default running time in ms, or 0 to repeat forever
+	Actuators   []BehaviourActuator `toml:"actuator" json:"actuators"`
+}
+
+// The trajectory of an actuator in a behaviour.
+type BehaviourActuator struct {
+	Name       string          `toml:"name" json:"name"`
+	Units      string          `toml:"units" json:"units,omitempty"`
+	Trajectory trajectory.Spec `toml:"trajectory" json:"trajectory"`
+}
+
+// A behaviour to play, with its parameters and its weight when blended
+// with others. Parameters not given take the defaults of the behaviour.
+type behaviourPart struct {
+	Name      string   `json:"name"`
+	Weight    *float64 `json:"weight"`    // relative weight in a blend, 1 by default
+	Intensity *float64 `json:"intensity"` // 0..1
+	Rate      *float64 `json:"rate"`      // cycles per minute
+	Duration  *uint32  `json:"duration"`  // running time in ms, or 0 to repeat forever
+}
+
+// The behaviours last started.
+type activeBehaviour struct {
+	Behaviours []behaviourPart `json:"behaviours"`
+	Actuators  []string        `json:"actuators"`
+	Started    time.Time       `json:"started"`
+	Until      *time.Time      `json:"until"` // time the motion ends, or null if it repeats forever
+
+	addrs []msgtype.RemoteAddress
+}
+
+// The motion of an actuator in one behaviour.
+type behaviourMotion struct {
+	info    msgtype.ActuatorInfo
+	units   string
+	tr      trajectory.Trajectory
+	forever bool // repeats tr until stopped
+	weight  float64
+}
+
+// Loaded behaviours and the behaviours last started.
+var behaviours = struct {
+	sync.Mutex
+	presets map[string]*Behaviour
+	active  *activeBehaviour
+	start   sync.Mutex // held while starting or stopping behaviours
+}{presets: make(map[string]*Behaviour)}
+
+// Read a behaviour file.
+func ReadBehaviour(r io.Reader) (*Behaviour, error) {
+	b := &Behaviour{}
+	md, err := toml.NewDecoder(r).Decode(b)
+	if err != nil {
+		return nil, err
+	}
+	if keys := md.Undecoded(); len(keys) > 0 {
+		names := make([]string, len(keys))
+		for i, key := range keys {
+			names[i] = key.String()
+		}
+		return nil, fmt.Errorf("unknown keys %s", strings.Join(names, ", "))
+	}
+	if b.Intensity == 0 {
+		b.Intensity = 1
+	}
+	if err := b.validate(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *Behaviour) validate() error {
+	if b.Name == "" {
+		return fmt.Errorf("missing behaviour name")
+	} else if b.Intensity < 0 || b.Intensity > 1 {
+		return fmt.Errorf("behaviour %q: intensity %g outside 0..1", b.Name, b.Intensity)
+	} else if b.Rate < 0 {
+		return fmt.Errorf("behaviour %q: negative rate", b.Name)
+	} else if len(b.Actuators) == 0 {
+		return fmt.Errorf("behaviour %q: no actuators", b.Name)
+	}
+
+	names := make(map[string]bool)
+	for _, a := range b.Actuators {
+		if a.Name == "" {
+			return fmt.Errorf("behaviour %q: missing actuator name", b.Name)
+		} else if names[a.Name] {
+			return fmt.Errorf("behaviour %q: duplicate actuator %q", b.Name, a.Name)
+		} else if checkUnits(a.Units) != nil {
+			return fmt.Errorf("behaviour %q: actuator %q: unknown units %q", b.Name, a.Name, a.Units)
+		}
+		names[a.Name] = true
+
+		// moves must say where they start
+		spec := a.Trajectory
+		switch spec.Type {
+		case trajectory.TypeLinear, trajectory.TypeMinJerk:
+			if spec.From == nil {
+				return fmt.Errorf("behaviour %q: actuator %q: from: missing", b.Name, a.Name)
+			}
+		}
+		if _, err := spec.Trajectory(); err != nil {
+			return fmt.Errorf("behaviour %q: actuator %q: %s", b.Name, a.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+// Load every behaviour file ending in .toml in a directory, replacing the
+// loaded behaviours. An empty name unloads them all.
+func LoadBehaviours(dir string) error {
+	presets, err := readBehaviours(dir)
+	if err != nil {
+		return err
+	}
+	setBehaviours(presets)
+	return nil
+}
+
+// Read the behaviour files in a directory, by name.
+func readBehaviours(dir string) (map[string]*Behaviour, error) {
+	presets := make(map[string]*Behaviour)
+	if dir == "" {
+		return presets, nil
+	}
+
+	names, err := filepath.Glob(filepath.Join(dir, "*.toml"))
+	if err != nil {
+		return nil, err
+	}
+	if names == nil {
+		if _, err := ioutil.ReadDir(dir); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range names {
+		b, err := loadBehaviour(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err.Error())
+		}
+		if presets[b.Name] != nil {
+			return nil, fmt.Errorf("%s: duplicate behaviour %q", name, b.Name)
+		}
+		presets[b.Name] = b
+	}
+	return presets, nil
+}
+
+// Replace the loaded behaviours.
+func setBehaviours(presets map[string]*Behaviour) {
+	behaviours.Lock()
+	behaviours.presets = presets
+	behaviours.Unlock()
+}
+
+func loadBehaviour(name string) (*Behaviour, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadBehaviour(f)
+}
+
+// Get the loaded behaviours, in order of name.
+func Behaviours() []*Behaviour {
+	behaviours.Lock()
+	defer behaviours.Unlock()
+
+	list := make([]*Behaviour, 0, len(behaviours.presets))
+	for _, b := range behaviours.presets {
+		list = append(list, b)
+	}
+	sort.Sort(byName(list))
+	return list
+}
+
+// Find a loaded behaviour by name.
+func LookupBehaviour(name string) (*Behaviour, bool) {
+	behaviours.Lock()
+	defer behaviours.Unlock()
+
+	b, ok := behaviours.presets[name]
+	return b, ok
+}
+
+type byName []*Behaviour
+
+func (l byName) Len() int           { return len(l) }
+func (l byName) Less(i, j int) bool { return l[i].Name < l[j].Name }
+func (l byName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// Get the trajectory of an actuator at an intensity, a rate relative to the
+// default and a running time, or 0 to keep the repeats of the file.
+func (a *BehaviourActuator) scaled(intensity, speed float64, d time.Duration) trajectory.Spec {
+	s := a.Trajectory
+	scale := func(from, to float64) float64 {
+		return from + (to-from)*intensity
+	}
+
+	var period float64
+	switch s.Type {
+	case trajectory.TypeLinear, trajectory.TypeMinJerk:
+		to := scale(*s.From, *s.To)
+		s.To = &to
+		s.Duration /= speed
+	case trajectory.TypeSine:
+		s.Amplitude *= intensity
+		s.Period /= speed
+		period = s.Period
+	case trajectory.TypeBreathe:
+		s.Amplitude *= intensity
+		s.Rate *= speed
+		period = 60000 / s.Rate
+	case trajectory.TypeSpline:
+		s.Keyframes = make([][2]float64, len(a.Trajectory.Keyframes))
+		for i, k := range a.Trajectory.Keyframes {
+			s.Keyframes[i] = [2]float64{k[0] / speed, scale(a.Trajectory.Keyframes[0][1], k[1])}
+		}
+	}
+
+	// waves repeat for the running time
+	if period > 0 && d > 0 {
+		s.Cycles = int(math.Ceil(float64(d/time.Millisecond) / period))
+	}
+	return s
+}
+
+// Get the motion of each actuator in a behaviour with the given parameters.
+func (b *Behaviour) motions(v *validator, field string, p *behaviourPart) []*behaviourMotion {
+	intensity, speed, weight := b.Intensity, 1.0, 1.0
+	d := time.Duration(b.Duration) * time.Millisecond
+
+	if p.Intensity != nil {
+		if intensity = *p.Intensity; intensity < 0 || intensity > 1 {
+			v.add(InvalidMessageError.withField(join(field, "intensity"), "%g outside 0..1", intensity))
+		}
+	}
+	if p.Rate != nil {
+		if b.Rate == 0 {
+			v.add(InvalidMessageError.withField(join(field, "rate"), "%s has no rate", b.Name))
+		} else if *p.Rate <= 0 || *p.Rate > 100*b.Rate {
+			v.add(InvalidMessageError.withField(join(field, "rate"), "%g out of range", *p.Rate))
+		} else {
+			speed = *p.Rate / b.Rate
+		}
+	}
+	if p.Duration != nil {
+		d = time.Duration(*p.Duration) * time.Millisecond
+	}
+	if p.Weight != nil {
+		if weight = *p.Weight; weight < 0 {
+			v.add(InvalidMessageError.withField(join(field, "weight"), "must not be negative"))
+		}
+	}
+
+	var motions []*behaviourMotion
+	for _, a := range b.Actuators {
+		info, ok := msgtype.LookupName(a.Name)
+		if !ok {
+			v.add(NotFoundError.withField(field, "%s: actuator %q", b.Name, a.Name))
+			continue
+		}
+
+		spec := a.scaled(intensity, speed, d)
+		tr, err := spec.Trajectory()
+		if err != nil {
+			v.add(InvalidMessageError.withField(field, "%s: %s: %s", b.Name, a.Name, err.Error()))
+			continue
+		}
+		motions = append(motions, &behaviourMotion{info, a.Units, tr, spec.Forever(), weight})
+	}
+	return motions
+}
+
+// Compile behaviours into a setpoint program for each actuator. Where
+// behaviours share an actuator, their positions are blended by weight in
+// the units of the first. Returns the running time, or 0 if any program
+// repeats forever.
+func compileBehaviours(v *validator, field string, parts []behaviourPart, resolution time.Duration) ([]*msgtype.Setpoint, time.Duration) {
+	var order []msgtype.RemoteAddress
+	blends := make(map[msgtype.RemoteAddress][]*behaviourMotion)
+
+	for i, p := range parts {
+		f := field
+		if field != "" {
+			f = fmt.Sprintf("%s[%d]", field, i)
+		}
+		b, ok := LookupBehaviour(p.Name)
+		if !ok {
+			v.add(NotFoundError.withField(join(f, "name"), "behaviour %q", p.Name))
+			continue
+		}
+		for _, m := range b.motions(v, f, &p) {
+			if blends[m.info.Addr] == nil {
+				order = append(order, m.info.Addr)
+			}
+			blends[m.info.Addr] = append(blends[m.info.Addr], m)
+		}
+	}
+	if len(*v) > 0 {
+		return nil, 0
+	}
+
+	var programs []*msgtype.Setpoint
+	var span time.Duration
+	forever := false
+	for _, addr := range order {
+		m, tr, loop, e := blend(blends[addr], resolution)
+		if e != nil {
+			v.add(e)
+			continue
+		}
+		values, err := trajectory.Sample(tr, resolution, addr, m.units)
+		switch err {
+		case nil:
+		case msgtype.InvalidPositionError:
+			v.add(InvalidSetpointError.withField(field, "%s: positions out of range", m.info.Name))
+			continue
+		default:
+			v.add(InvalidMessageError.withField(field, "%s: %s", m.info.Name, err.Error()))
+			continue
+		}
+
+		p := &msgtype.Setpoint{addr, 0, 0, values}
+		if loop {
+			p.Loop = msgtype.LOOP_INFINITE
+			forever = true
+		} else if tr.Duration() > span {
+			span = tr.Duration()
+		}
+		programs = append(programs, p)
+	}
+	if forever {
+		span = 0
+	}
+	return programs, span
+}
+
+// Blend the motions of an actuator, returning the first motion, whose units
+// are used, the blended trajectory and whether it loops forever. Motions
+// that repeat forever are blended over a common cycle if all of them repeat,
+// or otherwise over the running time of the others.
+func blend(motions []*behaviourMotion, resolution time.Duration) (*behaviourMotion, trajectory.Trajectory, bool, *Error) {
+	first := motions[0]
+	if len(motions) == 1 {
+		return first, first.tr, first.forever, nil
+	}
+
+	forever := true
+	var span time.Duration
+	for _, m := range motions {
+		if !m.forever {
+			forever = false
+			if d := m.tr.Duration(); d > span {
+				span = d
+			}
+		}
+	}
+
+	if forever {
+		// find a cycle in which every motion repeats a whole number of times
+		if resolution <= 0 {
+			resolution = trajectory.DefaultResolution
+		}
+		cycle := int64(1)
+		for _, m := range motions {
+			n := int64((m.tr.Duration() + resolution/2) / resolution)
+			if n == 0 {
+				n = 1
+			}
+			cycle = cycle / gcd(cycle, n) * n
+			if cycle > trajectory.MaxSamples {
+				return nil, nil, false, InvalidMessageError.withDetail(
+					"%s: cycles of blended behaviours do not line up", first.info.Name)
+			}
+		}
+		span = time.Duration(cycle) * resolution
+	}
+
+	b := &trajectory.Blend{}
+	for _, m := range motions {
+		var tr trajectory.Trajectory = convertUnits(m.tr, first.info, m.units, first.units)
+		if m.forever {
+			tr = &trajectory.Repeat{tr, span}
+		}
+		b.Parts = append(b.Parts, tr)
+		b.Weights = append(b.Weights, m.weight)
+	}
+	return first, b, forever, nil
+}
+
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// A trajectory converted from one position units to another.
+type unitsTrajectory struct {
+	trajectory.Trajectory
+	info     msgtype.ActuatorInfo
+	from, to string
+}
+
+// Convert a trajectory to other units for an actuator.
+func convertUnits(tr trajectory.Trajectory, info msgtype.ActuatorInfo, from, to string) trajectory.Trajectory {
+	if info.UnitsOf(from) == info.UnitsOf(to) {
+		return tr
+	}
+	return &unitsTrajectory{tr, info, from, to}
+}
+
+func (u *unitsTrajectory) At(t time.Duration) float64 {
+	v := u.Trajectory.At(t)
+	if u.info.UnitsOf(u.from) == msgtype.UnitsRaw {
+		v = math.Floor(v + 0.5)
+	}
+	p, err := u.info.Encode(v, u.from)
+	if err != nil {
+		return math.NaN()
+	}
+	v, _ = u.info.Decode(p, u.to)
+	return v
+}
+
+// Start behaviours on behalf of a client, blended where they share
+// actuators, in place of those last started. Actuators the behaviours
+// replaced no longer use are put to sleep.
+func startBehaviours(v *validator, field string, req *http.Request, parts []behaviourPart, resolution time.Duration) (*activeBehaviour, error) {
+	behaviours.start.Lock()
+	defer behaviours.start.Unlock()
+
+	programs, span := compileBehaviours(v, field, parts, resolution)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	prev := activeBehaviours()
+
+	a := &activeBehaviour{Behaviours: parts, Started: time.Now()}
+	for _, m := range programs {
+		if err := command(req, m); err != nil {
+			// stop the actuators already started rather than leave them
+			// running untracked
+			sleepActuators(req, a.addrs)
+			return nil, err
+		}
+		info, _ := msgtype.LookupAddress(m.Addr)
+		a.Actuators = append(a.Actuators, info.Name)
+		a.addrs = append(a.addrs, m.Addr)
+	}
+	if span > 0 {
+		until := a.Started.Add(span)
+		a.Until = &until
+	}
+
+	behaviours.Lock()
+	behaviours.active = a
+	behaviours.Unlock()
+
+	// stop the actuators of the behaviours replaced that are not reused
+	if prev != nil {
+		var unused []msgtype.RemoteAddress
+		for _, addr := range prev.addrs {
+			if !containsAddress(a.addrs, addr) {
+				unused = append(unused, addr)
+			}
+		}
+		if err := sleepActuators(req, unused); err != nil {
+			return a, err
+		}
+	}
+	return a, nil
+}
+
+// Stop the behaviours last started, putting their actuators to sleep.
+func stopBehaviours(req *http.Request) error {
+	behaviours.start.Lock()
+	defer behaviours.start.Unlock()
+
+	behaviours.Lock()
+	a := behaviours.active
+	behaviours.active = nil
+	behaviours.Unlock()
+
+	if a == nil {
+		return nil
+	}
+	return sleepActuators(req, a.addrs)
+}
+
+// Get the behaviours last started, if still running.
+func activeBehaviours() *activeBehaviour {
+	behaviours.Lock()
+	defer behaviours.Unlock()
+
+	a := behaviours.active
+	if a != nil && a.Until != nil && time.Now().After(*a.Until) {
+		behaviours.active = nil
+		return nil
+	}
+	return a
+}
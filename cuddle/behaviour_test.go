@@ -0,0 +1,111 @@
+package cuddle
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"../msgtype"
+	"../trajectory"
+)
+
+func TestLoadBehaviours(t *testing.T) {
+	if err := LoadBehaviours("../behaviours"); err != nil {
+		t.Fatal(err)
+	}
+	defer LoadBehaviours("")
+
+	var names []string
+	for _, b := range Behaviours() {
+		names = append(names, b.Name)
+	}
+	if strings.Join(names, " ") != "breathe nuzzle purr sleep" {
+		t.Fatalf("Unexpected behaviours %v", names)
+	}
+
+	for _, name := range names {
+		var v validator
+		programs, _ := compileBehaviours(&v, "", []behaviourPart{{Name: name}}, 0)
+		if err := v.err(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		b, _ := LookupBehaviour(name)
+		if len(programs) != len(b.Actuators) {
+			t.Fatalf("%s: expected %d programs, got %d", name, len(b.Actuators), len(programs))
+		}
+	}
+}
+
+func TestReadBehaviour(t *testing.T) {
+	for _, text := range []string{
+		`name = "x"`,
+		"name = \"x\"\n[[actuator]]\nname = \"ribs\"\ntrajectory = { type = \"linear\", to = 1, duration = 100 }",
+		"name = \"x\"\n[[actuator]]\nname = \"ribs\"\nspeed = 1\ntrajectory = { type = \"sine\", period = 100 }",
+	} {
+		if _, err := ReadBehaviour(strings.NewReader(text)); err == nil {
+			t.Fatalf("Expected error reading %q", text)
+		}
+	}
+}
+
+func TestCompileBehaviours(t *testing.T) {
+	behaviours.Lock()
+	behaviours.presets = map[string]*Behaviour{
+		"slow": {"slow", "", 1, 30, 0, []BehaviourActuator{
+			{"ribs", "norm", sineSpec(0.5, 0.5, 2000)},
+		}},
+		"fast": {"fast", "", 1, 60, 0, []BehaviourActuator{
+			{"ribs", "norm", sineSpec(0.5, 0.2, 1000)},
+		}},
+	}
+	behaviours.Unlock()
+	defer LoadBehaviours("")
+
+	// a single behaviour loops one cycle forever
+	var v validator
+	programs, span := compileBehaviours(&v, "", []behaviourPart{{Name: "slow"}}, 0)
+	if err := v.err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(programs) != 1 || len(programs[0].Setpoints) != 40 || programs[0].Loop != msgtype.LOOP_INFINITE || span != 0 {
+		t.Fatalf("Unexpected programs %+v, running time %v", programs, span)
+	}
+
+	// a faster rate shortens the cycle, and a running time stops it
+	rate, duration := 120.0, uint32(2000)
+	programs, span = compileBehaviours(&v, "", []behaviourPart{{Name: "slow", Rate: &rate, Duration: &duration}}, 0)
+	if len(programs) != 1 || len(programs[0].Setpoints) != 40 || programs[0].Loop != 0 || span != 2*time.Second {
+		t.Fatalf("Unexpected programs %+v, running time %v", programs, span)
+	}
+
+	// blended waves loop over a common cycle, and positions are averaged
+	weight := 3.0
+	parts := []behaviourPart{{Name: "slow"}, {Name: "fast", Weight: &weight}}
+	programs, _ = compileBehaviours(&v, "behaviours", parts, 0)
+	if err := v.err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(programs) != 1 || len(programs[0].Setpoints) != 40 || programs[0].Loop != msgtype.LOOP_INFINITE {
+		t.Fatalf("Unexpected programs %+v", programs)
+	}
+	info, _ := msgtype.LookupAddress(msgtype.RibsAddress)
+	expect, _ := info.Encode((0.5+0.5*math.Sqrt(0.5)+3*(0.5+0.2))/4, "norm")
+	if got := programs[0].Setpoints[4].Setpoint; got < expect-1 || got > expect+1 {
+		t.Fatalf("Expected blended setpoint %d, got %d", expect, got)
+	}
+
+	// errors name the part at fault
+	intensity := 2.0
+	parts = []behaviourPart{{Name: "slow"}, {Name: "fast", Intensity: &intensity}, {Name: "none"}}
+	v = nil
+	compileBehaviours(&v, "behaviours", parts, 0)
+	if len(v) != 2 || v[0].Field != "behaviours[1].intensity" || v[1].Field != "behaviours[2].name" {
+		t.Fatalf("Unexpected errors %v", v)
+	}
+}
+
+func sineSpec(center, amplitude, period float64) (s trajectory.Spec) {
+	s.Type, s.Center, s.Amplitude, s.Period = "sine", center, amplitude, period
+	return
+}
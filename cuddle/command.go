@@ -0,0 +1,47 @@
+package cuddle
+
+import (
+	"encoding"
+	"net/http"
+
+	"../msgtype"
+)
+
+// Send a command to an actuator on behalf of a client, renewing the lease
+// named in the request, if any, and recording that it drives the actuator.
+// Setpoint programs too long for one message are sent in chunks.
+func command(req *http.Request, m encoding.BinaryMarshaler) error {
+	lease, err := requestLease(req)
+	if err != nil {
+		return err
+	}
+
+	// record the actuator first, so that it is put to sleep should the
+	// lease expire while the command is sent
+	undo := func() {}
+	if !isSleep(m) {
+		undo = driveLease(lease, msgtype.AddressOf(m))
+	}
+
+	defer setOrigin(m, req.Header.Get(RequestIDHeader))()
+	if sp, ok := m.(*msgtype.Setpoint); ok {
+		err = SendProgram(sp)
+	} else {
+		err = Send(m)
+	}
+	if err != nil {
+		undo()
+	}
+	return err
+}
+
+// Put actuators to sleep on behalf of a client, returning the first error.
+func sleepActuators(req *http.Request, addrs []msgtype.RemoteAddress) error {
+	var err error
+	for _, addr := range addrs {
+		if e := command(req, &msgtype.Sleep{addr}); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,329 @@
+package cuddle
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"../msgtype"
+)
+
+// Server configuration, read from a TOML file.
+type Config struct {
+	Listen       string           `toml:"listen"`        // address on which to listen
+	Poll         Duration         `toml:"poll"`          // interval at which to read positions, or 0 for none
+	LeaseTimeout Duration         `toml:"lease_timeout"` // default time before an unrenewed lease expires
+	Behaviours   string           `toml:"behaviours"`    // directory of behaviour files, or empty for none
+	Serial       SerialConfig     `toml:"serial"`
+	Auth         AuthConfig       `toml:"auth"`
+	Log          LogConfig        `toml:"log"`
+	Actuators    []ActuatorConfig `toml:"actuator"`
+}
+
+// Serial port settings.
+type SerialConfig struct {
+	Port       string `toml:"port"`
+	Baud       int    `toml:"baud"`
+	LowLatency bool   `toml:"low_latency"`
+	Restore    bool   `toml:"restore"` // resend PID coefficients and setpoints after reconnecting
+}
+
+// Authentication settings.
+type AuthConfig struct {
+	Token string `toml:"token"` // bearer token required on requests, or empty for none
+}
+
+// Logging settings.
+type LogConfig struct {
+	Debug  bool   `toml:"debug"`
+	File   string `toml:"file"`   // file to which to log, or empty for stdout and stderr
+	Record string `toml:"record"` // file to which to record sessions, or empty for none
+}
+
+// Settings for an actuator.
+type ActuatorConfig struct {
+	Name         string     `toml:"name"`
+	Addr         string     `toml:"addr"` // single character board address, optional for built-in actuators
+	Description  string     `toml:"description"`
+	Capabilities []string   `toml:"capabilities"` // commands accepted, or all if not given
+	Range        *[2]uint16 `toml:"range"`        // positions reached
+	Units        string     `toml:"units"`        // default units of positions: raw, deg, rad or norm
+	Offset       uint16     `toml:"offset"`       // position of zero degrees
+	Reversed     bool       `toml:"reversed"`     // angles increase towards lower positions
+	PID          *PIDConfig `toml:"pid"`          // PID coefficients sent on connecting
+
+	// Soft limits. Fields that are not given are not limited.
+	Limits    Limits         `toml:"-"`
+	RawLimits toml.Primitive `toml:"limits"`
+}
+
+// PID coefficients.
+type PIDConfig struct {
+	Kp float32 `toml:"kp"`
+	Ki float32 `toml:"ki"`
+	Kd float32 `toml:"kd"`
+}
+
+// Duration in a configuration file, written as in time.ParseDuration.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// PID coefficients sent to each actuator on connecting.
+var defaultPID = struct {
+	sync.Mutex
+	m map[msgtype.RemoteAddress]msgtype.SetPID
+}{m: make(map[msgtype.RemoteAddress]msgtype.SetPID)}
+
+var configErr = log.New(logErr, "[config] ", 0)
+
+// Get the default configuration.
+func DefaultConfig() *Config {
+	return &Config{
+		Listen:       ":http",
+		Poll:         Duration{250 * time.Millisecond},
+		LeaseTimeout: Duration{DefaultLeaseTimeout},
+		Serial: SerialConfig{
+			Port:       "/dev/ttyUSB0",
+			Baud:       DefaultPortConfig.Baud,
+			LowLatency: DefaultPortConfig.LowLatency,
+		},
+	}
+}
+
+// Load a configuration file. Settings that are not given keep their default.
+func LoadConfig(name string) (*Config, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c, err := ReadConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err.Error())
+	}
+	return c, nil
+}
+
+// Read a configuration. Unknown keys are an error.
+func ReadConfig(r io.Reader) (*Config, error) {
+	c := DefaultConfig()
+	md, err := toml.NewDecoder(r).Decode(c)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range c.Actuators {
+		a := &c.Actuators[i]
+		a.Limits = NoLimits
+		if err := md.PrimitiveDecode(a.RawLimits, &a.Limits); err != nil {
+			return nil, err
+		}
+	}
+
+	if keys := md.Undecoded(); len(keys) > 0 {
+		names := make([]string, len(keys))
+		for i, key := range keys {
+			names[i] = key.String()
+		}
+		return nil, fmt.Errorf("unknown keys %s", strings.Join(names, ", "))
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) validate() error {
+	list, err := c.actuators()
+	if err != nil {
+		return err
+	}
+	if err := msgtype.CheckActuators(list); err != nil {
+		return err
+	}
+	for _, a := range c.Actuators {
+		if a.Limits.Min > a.Limits.Max {
+			return fmt.Errorf("actuator %q: min %d > max %d", a.Name, a.Limits.Min, a.Limits.Max)
+		}
+	}
+	if c.Serial.Baud <= 0 {
+		return fmt.Errorf("invalid baud rate %d", c.Serial.Baud)
+	}
+	if c.LeaseTimeout.Duration <= 0 {
+		return fmt.Errorf("invalid lease timeout %s", c.LeaseTimeout.Duration)
+	}
+	return nil
+}
+
+// Get the registry entries for the configured actuators, or the built-in
+// actuators if none are configured.
+func (c *Config) actuators() ([]msgtype.ActuatorInfo, error) {
+	if len(c.Actuators) == 0 {
+		return msgtype.DefaultActuators(), nil
+	}
+
+	list := make([]msgtype.ActuatorInfo, len(c.Actuators))
+	for i, a := range c.Actuators {
+		info, err := a.info()
+		if err != nil {
+			return nil, err
+		}
+		list[i] = info
+	}
+	return list, nil
+}
+
+// Get the registry entry for an actuator.
+func (a *ActuatorConfig) info() (msgtype.ActuatorInfo, error) {
+	info := msgtype.ActuatorInfo{
+		Name:         a.Name,
+		Description:  a.Description,
+		Capabilities: a.Capabilities,
+		Range:        [2]uint16{0, 0xffff},
+		Units:        a.Units,
+		Offset:       a.Offset,
+		Reversed:     a.Reversed,
+	}
+	if a.Range != nil {
+		info.Range = *a.Range
+	}
+
+	switch len(a.Addr) {
+	case 0:
+		// built-in actuators need no address
+		for _, b := range msgtype.DefaultActuators() {
+			if b.Name == a.Name {
+				info.Addr = b.Addr
+				if info.Description == "" {
+					info.Description = b.Description
+				}
+				return info, nil
+			}
+		}
+		return info, fmt.Errorf("actuator %q: missing address", a.Name)
+	case 1:
+		info.Addr = msgtype.RemoteAddress(a.Addr[0])
+		return info, nil
+	}
+	return info, fmt.Errorf("actuator %q: address %q is not a single character", a.Name, a.Addr)
+}
+
+// Register the configured actuators, replacing the registry.
+func (c *Config) Register() error {
+	list, err := c.actuators()
+	if err != nil {
+		return err
+	}
+	return msgtype.SetActuators(list)
+}
+
+// Apply the settings that may change while running: logging, recording,
+// authentication, the lease timeout, the actuator registry, behaviours,
+// limits and default PID coefficients. Changed PID coefficients are sent to
+// the actuators straight away. The actuators and behaviours are checked
+// before anything is changed, so that a bad configuration is not half
+// applied.
+func (c *Config) Apply() error {
+	list, err := c.actuators()
+	if err != nil {
+		return err
+	}
+	if err := msgtype.CheckActuators(list); err != nil {
+		return err
+	}
+	presets, err := readBehaviours(c.Behaviours)
+	if err != nil {
+		return err
+	}
+
+	lm := make(map[msgtype.RemoteAddress]Limits)
+	pid := make(map[msgtype.RemoteAddress]msgtype.SetPID)
+	for i, a := range c.Actuators {
+		addr := list[i].Addr
+		lm[addr] = a.Limits
+		if a.PID != nil {
+			pid[addr] = msgtype.SetPID{addr, a.PID.Kp, a.PID.Ki, a.PID.Kd}
+		}
+	}
+
+	// open both files before changing anything, so that a bad path leaves
+	// the configuration as it was
+	logf, err := openLogFile(c.Log.File)
+	if err != nil {
+		return err
+	}
+	recordf, err := openLogFile(c.Log.Record)
+	if err != nil {
+		if logf != nil {
+			logf.Close()
+		}
+		return err
+	}
+
+	useLogFile(logf)
+	useRecordFile(recordf)
+	msgtype.SetActuators(list)
+	Debug = c.Log.Debug
+	SetAuthToken(c.Auth.Token)
+	DefaultLeaseTimeout = c.LeaseTimeout.Duration
+	setBehaviours(presets)
+
+	limits.Lock()
+	limits.m = lm
+	limits.Unlock()
+
+	defaultPID.Lock()
+	var changed []msgtype.SetPID
+	for addr, m := range pid {
+		if old, ok := defaultPID.m[addr]; !ok || old != m {
+			changed = append(changed, m)
+		}
+	}
+	defaultPID.m = pid
+	defaultPID.Unlock()
+
+	if len(changed) > 0 && Connection().State == Connected {
+		go sendPID(changed)
+	}
+
+	return nil
+}
+
+// Send the default PID coefficients to all actuators.
+func sendDefaultPID() {
+	defaultPID.Lock()
+	messages := make([]msgtype.SetPID, 0, len(defaultPID.m))
+	for _, m := range defaultPID.m {
+		messages = append(messages, m)
+	}
+	defaultPID.Unlock()
+
+	sendPID(messages)
+}
+
+func sendPID(messages []msgtype.SetPID) {
+	for i := range messages {
+		if err := Send(&messages[i]); err != nil {
+			configErr.Printf("Failed to send PID coefficients %+v %s", messages[i], err.Error())
+		}
+	}
+}
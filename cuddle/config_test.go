@@ -0,0 +1,184 @@
+package cuddle
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"../msgtype"
+)
+
+func TestReadConfig(t *testing.T) {
+	c, err := ReadConfig(bytes.NewBufferString(`
+listen = ":8080"
+poll = "100ms"
+
+[serial]
+port = "/tmp/cuddlebot"
+
+[[actuator]]
+name = "heady"
+addr = "y"
+pid = { kp = 40.4, ki = 1, kd = -1 }
+limits = { min = 1000, max_slew = 8000 }
+
+[[actuator]]
+name = "tail"
+addr = "t"
+capabilities = ["sleep", "setpoint"]
+range = [16384, 49152]
+units = "deg"
+offset = 32768
+reversed = true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Listen != ":8080" || c.Poll.Duration != 100*time.Millisecond {
+		t.Fatalf("unexpected settings %+v", c)
+	}
+	// settings not given keep their default
+	if c.Serial.Baud != DefaultPortConfig.Baud || c.LeaseTimeout.Duration != DefaultLeaseTimeout {
+		t.Fatalf("unexpected defaults %+v", c)
+	}
+	if len(c.Actuators) != 2 {
+		t.Fatalf("expected 2 actuators, got %d", len(c.Actuators))
+	}
+	a := c.Actuators[0]
+	if *a.PID != (PIDConfig{40.4, 1, -1}) {
+		t.Fatalf("unexpected PID %+v", *a.PID)
+	}
+	if a.Limits != (Limits{1000, 0xffff, 8000, 0}) {
+		t.Fatalf("unexpected limits %+v", a.Limits)
+	}
+
+	if err := c.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	defer DefaultConfig().Apply()
+
+	if l := GetLimits(msgtype.HeadYAddress); l != a.Limits {
+		t.Fatalf("limits not applied %+v", l)
+	}
+
+	// the registry lists only the configured actuators
+	var addr msgtype.RemoteAddress
+	if err := addr.UnmarshalText([]byte("tail")); err != nil || addr != 't' {
+		t.Fatalf("tail not registered: %v", err)
+	}
+	if err := addr.UnmarshalText([]byte("ribs")); err == nil {
+		t.Fatal("ribs still registered")
+	}
+
+	// commands the actuator cannot accept are rejected
+	tr := NewTransport()
+	if err := tr.Send(&msgtype.SetPID{Addr: 't'}, time.Second); err == nil ||
+		err.(*Error).Message != NotSupportedError.Message {
+		t.Fatalf("expected %v, got %v", NotSupportedError, err)
+	}
+}
+
+func TestReadConfigErrors(t *testing.T) {
+	for _, s := range []string{
+		`listen = 80`,
+		`poll = "soon"`,
+		`colour = "blue"`,
+		`[serial]
+		speed = 9600`,
+		`[[actuator]]
+		name = "tail"`,
+		`[[actuator]]
+		name = "tail"
+		addr = "tl"`,
+		`[[actuator]]
+		name = "tail"
+		addr = "p"
+		[[actuator]]
+		name = "purr"`,
+		`[[actuator]]
+		name = "tail"
+		addr = "t"
+		capabilities = ["wag"]`,
+		`[[actuator]]
+		name = "tail"
+		addr = "t"
+		units = "furlongs"`,
+		`[[actuator]]
+		name = "ribs"
+		[[actuator]]
+		name = "ribs"`,
+		`[[actuator]]
+		name = "ribs"
+		limits = { min = 9000, max = 1000 }`,
+		`[[actuator]]
+		name = "ribs"
+		limits = { maximum = 1000 }`,
+		`lease_timeout = "0s"`,
+		`lease_timeout = "-1s"`,
+	} {
+		if _, err := ReadConfig(bytes.NewBufferString(s)); err == nil {
+			t.Fatalf("expected error for %q", s)
+		}
+	}
+}
+
+func TestExampleConfig(t *testing.T) {
+	if _, err := LoadConfig("../cuddled/cuddled.toml"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApplyConfigErrors(t *testing.T) {
+	SetAuthToken("old")
+	defer SetAuthToken("")
+
+	c, err := ReadConfig(bytes.NewBufferString(`
+behaviours = "/nonexistent/behaviours"
+
+[auth]
+token = "new"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Apply(); err == nil {
+		t.Fatal("expected error loading behaviours")
+	}
+
+	// nothing is applied
+	authToken.Lock()
+	token := authToken.token
+	authToken.Unlock()
+	if token != "old" {
+		t.Fatalf("expected token to be unchanged, got %q", token)
+	}
+
+	// nor is the log file switched when the record file cannot be opened
+	dir, err := ioutil.TempDir("", "cuddle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err = ReadConfig(bytes.NewBufferString(`
+[log]
+file = "` + filepath.Join(dir, "cuddled.log") + `"
+record = "` + filepath.Join(dir, "missing", "session.jsonl") + `"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Apply(); err == nil {
+		t.Fatal("expected error opening the record file")
+	}
+	logFile.Lock()
+	f := logFile.f
+	logFile.Unlock()
+	if f != nil {
+		t.Fatalf("expected log file to be unchanged, got %s", f.Name())
+	}
+}
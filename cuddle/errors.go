@@ -1,19 +1,73 @@
 package cuddle
 
+import (
+	"fmt"
+	"net/http"
+)
+
 type Error struct {
-	OK      bool   `json:"ok"`
-	Message string `json:"error,omitempty"`
+	OK        bool     `json:"ok"`
+	Message   string   `json:"error,omitempty"`
+	Code      string   `json:"code,omitempty"` // machine-readable error code
+	Detail    string   `json:"detail,omitempty"`
+	Field     string   `json:"field,omitempty"`      // request field at fault, if any
+	RequestID string   `json:"request_id,omitempty"` // ID of the failed request
+	Errors    []*Error `json:"errors,omitempty"`     // every error found, if several
+
+	status int // HTTP status code
 }
 
 var (
-	InvalidAddressError  = &Error{Message: "InvalidAddressError"}
-	InvalidMessageError  = &Error{Message: "InvalidMessageError"}
-	InvalidSetpointError = &Error{Message: "InvalidSetpointError"}
-	MethodNotAllowed     = &Error{Message: "MethodNotAllowed"}
-	MissingFieldError    = &Error{Message: "MissingFieldError"}
-	NotImplementedError  = &Error{Message: "NotImplementedError"}
+	EmergencyStopError   = &Error{Message: "EmergencyStopError", Code: "emergency_stop", status: http.StatusConflict}
+	InternalServerError  = &Error{Message: "InternalServerError", Code: "internal", status: http.StatusInternalServerError}
+	InvalidAddressError  = &Error{Message: "InvalidAddressError", Code: "invalid_address", status: http.StatusBadRequest}
+	InvalidLeaseError    = &Error{Message: "InvalidLeaseError", Code: "invalid_lease", status: http.StatusBadRequest}
+	InvalidMessageError  = &Error{Message: "InvalidMessageError", Code: "invalid_message", status: http.StatusBadRequest}
+	InvalidSetpointError = &Error{Message: "InvalidSetpointError", Code: "invalid_setpoint", status: http.StatusBadRequest}
+	InvalidUnitsError    = &Error{Message: "InvalidUnitsError", Code: "invalid_units", status: http.StatusBadRequest}
+	LeaseExpiredError    = &Error{Message: "LeaseExpiredError", Code: "lease_expired", status: http.StatusConflict}
+	MethodNotAllowed     = &Error{Message: "MethodNotAllowed", Code: "method_not_allowed", status: http.StatusMethodNotAllowed}
+	MissingFieldError    = &Error{Message: "MissingFieldError", Code: "missing_field", status: http.StatusBadRequest}
+	NotFoundError        = &Error{Message: "NotFoundError", Code: "not_found", status: http.StatusNotFound}
+	NotImplementedError  = &Error{Message: "NotImplementedError", Code: "not_implemented", status: http.StatusNotImplemented}
+	NotSupportedError    = &Error{Message: "NotSupportedError", Code: "not_supported", status: http.StatusBadRequest}
+	QueueFullError       = &Error{Message: "QueueFullError", Code: "queue_full", status: http.StatusServiceUnavailable}
+	SerialDownError      = &Error{Message: "SerialDownError", Code: "serial_down", status: http.StatusServiceUnavailable}
+	SupersededError      = &Error{Message: "SupersededError", Code: "superseded", status: http.StatusConflict}
+	TimeoutError         = &Error{Message: "TimeoutError", Code: "timeout", status: http.StatusGatewayTimeout}
+	UnauthorizedError    = &Error{Message: "UnauthorizedError", Code: "unauthorized", status: http.StatusUnauthorized}
 )
 
 func (e *Error) Error() string {
+	if e.Detail != "" {
+		return e.Message + ": " + e.Detail
+	}
 	return e.Message
 }
+
+// Get the HTTP status code for the error.
+func (e *Error) Status() int {
+	if e.status == 0 {
+		return http.StatusInternalServerError
+	}
+	return e.status
+}
+
+// Copy an error, adding details.
+func (e *Error) withDetail(format string, a ...interface{}) *Error {
+	c := *e
+	c.Detail = fmt.Sprintf(format, a...)
+	return &c
+}
+
+// Copy an error, adding the field at fault and details.
+func (e *Error) withField(field, format string, a ...interface{}) *Error {
+	c := e.withDetail(format, a...)
+	c.Field = field
+	return c
+}
+
+// Make a missing field error for a request field.
+func missingField(field string) *Error {
+	return MissingFieldError.withField(field, "%s", field)
+}
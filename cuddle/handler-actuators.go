@@ -0,0 +1,40 @@
+package cuddle
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"../msgtype"
+)
+
+type actuatorMessage struct {
+	msgtype.ActuatorInfo
+	Address string `json:"address"` // board address character
+	Limits  Limits `json:"limits"`
+}
+
+type actuatorsResponse struct {
+	OK        bool               `json:"ok"`
+	Actuators []*actuatorMessage `json:"actuators"`
+}
+
+func actuatorsHandler(w http.ResponseWriter, req *http.Request, body io.Reader) error {
+	if req.Method != "GET" {
+		return MethodNotAllowed
+	}
+
+	var actuators []*actuatorMessage
+	for _, a := range msgtype.Actuators() {
+		actuators = append(actuators, &actuatorMessage{
+			ActuatorInfo: a,
+			Address:      string(rune(a.Addr)),
+			Limits:       GetLimits(a.Addr),
+		})
+	}
+
+	return json.NewEncoder(w).Encode(&actuatorsResponse{
+		OK:        true,
+		Actuators: actuators,
+	})
+}
@@ -1,18 +1,69 @@
 package cuddle
 
 import (
+	"encoding/json"
 	"io"
 	"net/http"
+	"time"
+
+	"../msgtype"
 )
 
 type dataMessage struct {
+	Addr     msgtype.RemoteAddress `json:"addr"`
+	Position *float64              `json:"position"`
+	Setpoint *float64              `json:"setpoint"`
+	Units    string                `json:"units"`
+	Sleeping bool                  `json:"sleeping"`
+	LastSeen *time.Time            `json:"last_seen"`
+}
+
+type dataResponse struct {
+	OK   bool           `json:"ok"`
+	Data []*dataMessage `json:"data"`
 }
 
 func dataHandler(w http.ResponseWriter, req *http.Request, body io.Reader) error {
 	if req.Method != "GET" {
 		return MethodNotAllowed
 	}
-	// http://wiki.analog.com/software/linux/docs/iio/iio
-	// https://archive.fosdem.org/2012/schedule/event/693/127_iio-a-new-subsystem.pdf
-	return NotImplementedError
+
+	query := req.URL.Query()
+
+	// filter by address
+	var addrs []msgtype.RemoteAddress
+	for _, s := range query["addr"] {
+		var addr msgtype.RemoteAddress
+		if err := addr.UnmarshalText([]byte(s)); err != nil {
+			return InvalidAddressError.withField("addr", "%q", s)
+		}
+		addrs = append(addrs, addr)
+	}
+
+	// filter by time window
+	var since, until time.Time
+	if s := query.Get("since"); s != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, s); err != nil {
+			return InvalidMessageError.withField("since", "%s", err.Error())
+		}
+	}
+	if s := query.Get("until"); s != "" {
+		var err error
+		if until, err = time.Parse(time.RFC3339, s); err != nil {
+			return InvalidMessageError.withField("until", "%s", err.Error())
+		}
+	}
+
+	// report positions in the given units, or raw
+	units := query.Get("units")
+	if err := checkUnits(units); err != nil {
+		return err
+	}
+	units = v1Units(units)
+
+	return json.NewEncoder(w).Encode(&dataResponse{
+		OK:   true,
+		Data: telemetry.data(addrs, since, until, units),
+	})
 }
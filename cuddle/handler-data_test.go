@@ -0,0 +1,39 @@
+package cuddle
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDataHandler(t *testing.T) {
+	handler := makeHandler(dataHandler)
+
+	for query, status := range map[string]int{
+		"":                               200,
+		"?addr=ribs&addr=purr&units=deg": 200,
+		"?since=2016-01-02T15:04:05Z":    200,
+		"?until=2016-01-02T15:04:05Z":    200,
+		"?addr=zz":                       400,
+		"?since=yesterday":               400,
+		"?until=tomorrow":                400,
+		"?units=furlongs":                400,
+	} {
+		req, _ := http.NewRequest("GET", "/1/data.json"+query, nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != status {
+			t.Errorf("%q: expected status %d, got %d %s", query, status, w.Code, w.Body)
+			continue
+		}
+		if status != 200 {
+			continue
+		}
+
+		var data dataResponse
+		if err := json.NewDecoder(w.Body).Decode(&data); err != nil || !data.OK || data.Data == nil {
+			t.Errorf("%q: unexpected response %s %v", query, w.Body, err)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package cuddle
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+type estopResponse struct {
+	OK      bool `json:"ok"`
+	Latched bool `json:"latched"`
+}
+
+func estopHandler(w http.ResponseWriter, req *http.Request, body io.Reader) error {
+	switch req.Method {
+	case "GET":
+	case "PUT":
+		// stays latched even if the port is down
+		if err := DefaultTransport.EmergencyStop(); err != nil {
+			return err
+		}
+	case "DELETE":
+		DefaultTransport.Reset()
+	default:
+		return MethodNotAllowed
+	}
+
+	return json.NewEncoder(w).Encode(&estopResponse{
+		OK:      true,
+		Latched: DefaultTransport.Latched(),
+	})
+}
@@ -0,0 +1,75 @@
+package cuddle
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+)
+
+type leaseMessage struct {
+	ID      string `json:"id"`
+	Client  string `json:"client"`
+	Timeout int64  `json:"timeout"` // in ms
+}
+
+type leaseResponse struct {
+	OK    bool   `json:"ok"`
+	Lease *Lease `json:"lease"`
+}
+
+type leasesResponse struct {
+	OK     bool     `json:"ok"`
+	Leases []*Lease `json:"leases"`
+}
+
+func leaseHandler(w http.ResponseWriter, req *http.Request, body io.Reader) error {
+	var lease *Lease
+	var err error
+
+	switch req.Method {
+	case "GET":
+		id := req.URL.Query().Get("id")
+		if id == "" {
+			return json.NewEncoder(w).Encode(&leasesResponse{
+				OK:     true,
+				Leases: listLeases(),
+			})
+		}
+		lease, err = getLease(id)
+
+	case "PUT":
+		var data leaseMessage
+		if err := decodeBody(body, &data); err != nil {
+			return err
+		}
+		if data.Timeout < 0 {
+			return InvalidMessageError.withDetail("timeout must be positive")
+		}
+		if data.ID == "" {
+			lease, err = createLease(data.Client,
+				time.Duration(data.Timeout)*time.Millisecond)
+		} else {
+			lease, err = renewLease(data.ID)
+		}
+
+	case "DELETE":
+		if err := releaseLease(req.URL.Query().Get("id")); err != nil {
+			return err
+		}
+		io.WriteString(w, `{"ok":true}`)
+		return nil
+
+	default:
+		return MethodNotAllowed
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(&leaseResponse{
+		OK:    true,
+		Lease: lease,
+	})
+}
@@ -0,0 +1,39 @@
+package cuddle
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"../msgtype"
+)
+
+type pingResponse struct {
+	OK   bool                  `json:"ok"`
+	Addr msgtype.RemoteAddress `json:"addr"`
+	RTT  float64               `json:"rtt"` // round trip time in ms
+}
+
+func pingHandler(w http.ResponseWriter, req *http.Request, body io.Reader) error {
+	if req.Method != "GET" {
+		return MethodNotAllowed
+	}
+
+	var addr msgtype.RemoteAddress
+	if err := addr.UnmarshalText([]byte(req.URL.Query().Get("addr"))); err != nil {
+		return InvalidAddressError
+	}
+
+	start := time.Now()
+	if _, err := Request(&msgtype.Ping{addr}); err != nil {
+		return err
+	}
+	rtt := time.Since(start)
+
+	return json.NewEncoder(w).Encode(&pingResponse{
+		OK:   true,
+		Addr: addr,
+		RTT:  rtt.Seconds() * 1000,
+	})
+}
@@ -1,7 +1,6 @@
 package cuddle
 
 import (
-	"encoding/json"
 	"io"
 	"net/http"
 
@@ -16,8 +15,13 @@ type setpidMessage struct {
 }
 
 func (s *setpidMessage) bind(m *msgtype.SetPID) error {
-	if s.Addr == nil {
-		return InvalidMessageError
+	var v validator
+	v.require("addr", s.Addr != nil)
+	v.require("kp", s.Kp != nil)
+	v.require("ki", s.Ki != nil)
+	v.require("kd", s.Kd != nil)
+	if err := v.err(); err != nil {
+		return err
 	}
 
 	m.Addr = *s.Addr
@@ -30,13 +34,12 @@ func (s *setpidMessage) bind(m *msgtype.SetPID) error {
 
 func setpidHandler(w http.ResponseWriter, req *http.Request, body io.Reader) error {
 	if req.Method != "PUT" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
 		return MethodNotAllowed
 	}
 
 	var data setpidMessage
-	if err := json.NewDecoder(body).Decode(&data); err != nil {
-		return &Error{Message: err.Error()}
+	if err := decodeBody(body, &data); err != nil {
+		return err
 	}
 
 	var message msgtype.SetPID
@@ -44,7 +47,9 @@ func setpidHandler(w http.ResponseWriter, req *http.Request, body io.Reader) err
 		return err
 	}
 
-	QueueMessage(&message)
+	if err := command(req, &message); err != nil {
+		return err
+	}
 
 	io.WriteString(w, `{"ok":true}`)
 
@@ -1,7 +1,6 @@
 package cuddle
 
 import (
-	"encoding/json"
 	"io"
 	"net/http"
 
@@ -12,23 +11,25 @@ type setpointMessage struct {
 	Addr      *msgtype.RemoteAddress `json:"addr"`
 	Delay     uint16                 `json:"delay"`
 	Loop      *uint16                `json:"loop"`
-	Setpoints *[]uint16              `json:"setpoints"`
+	Setpoints *[]float64             `json:"setpoints"` // duration and position pairs
+	Units     string                 `json:"units"`     // units of positions
 }
 
 func (s *setpointMessage) bind(m *msgtype.Setpoint) error {
-	if s.Addr == nil || s.Loop == nil || s.Setpoints == nil {
-		return InvalidMessageError
+	var v validator
+	v.require("addr", s.Addr != nil)
+	v.require("loop", s.Loop != nil)
+	v.require("setpoints", s.Setpoints != nil)
+	if err := v.err(); err != nil {
+		return err
 	}
 
-	spvalues := *s.Setpoints
-	nsetpoints := len(spvalues)
-	setpoints := make([]msgtype.SetpointValue, nsetpoints/2)
-
-	for i := 0; i < nsetpoints; i += 2 {
-		setpoints[i/2] = msgtype.SetpointValue{
-			Duration: spvalues[i],
-			Setpoint: spvalues[i+1],
-		}
+	var setpoints []msgtype.SetpointValue
+	if v.units("units", s.Units) {
+		setpoints = v.setpoints("setpoints", *s.Addr, *s.Setpoints, v1Units(s.Units))
+	}
+	if err := v.err(); err != nil {
+		return err
 	}
 
 	m.Addr = *s.Addr
@@ -41,13 +42,12 @@ func (s *setpointMessage) bind(m *msgtype.Setpoint) error {
 
 func setpointHandler(w http.ResponseWriter, req *http.Request, body io.Reader) error {
 	if req.Method != "PUT" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
 		return MethodNotAllowed
 	}
 
 	var data setpointMessage
-	if err := json.NewDecoder(body).Decode(&data); err != nil {
-		return &Error{Message: err.Error()}
+	if err := decodeBody(body, &data); err != nil {
+		return err
 	}
 
 	var message msgtype.Setpoint
@@ -55,7 +55,9 @@ func setpointHandler(w http.ResponseWriter, req *http.Request, body io.Reader) e
 		return err
 	}
 
-	QueueMessage(&message)
+	if err := command(req, &message); err != nil {
+		return err
+	}
 
 	io.WriteString(w, `{"ok":true}`)
 
@@ -1,7 +1,6 @@
 package cuddle
 
 import (
-	"encoding/json"
 	"io"
 	"net/http"
 
@@ -14,21 +13,22 @@ type sleepMessage struct {
 
 func sleepHandler(w http.ResponseWriter, req *http.Request, body io.Reader) error {
 	if req.Method != "PUT" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
 		return MethodNotAllowed
 	}
 
 	var data sleepMessage
-	if err := json.NewDecoder(body).Decode(&data); err != nil {
-		return &Error{Message: err.Error()}
+	if err := decodeBody(body, &data); err != nil {
+		return err
 	}
 
 	if data.Addr == nil || len(*data.Addr) == 0 {
-		return InvalidMessageError
+		return missingField("addr")
 	}
 
 	for _, addr := range *data.Addr {
-		QueueMessage(&msgtype.Sleep{addr})
+		if err := command(req, &msgtype.Sleep{addr}); err != nil {
+			return err
+		}
 	}
 
 	io.WriteString(w, `{"ok":true}`)
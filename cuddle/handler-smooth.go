@@ -1,7 +1,6 @@
 package cuddle
 
 import (
-	"encoding/json"
 	"io"
 	"net/http"
 
@@ -11,19 +10,29 @@ import (
 type smoothMessage struct {
 	Addr      *msgtype.RemoteAddress `json:"addr"`
 	Time 	   *uint16             	`json:"time"`
-	Setpoint *[]uint16              `json:"setpoint"`
+	Setpoint *[]float64             `json:"setpoint"` // duration and position pairs
+	Units    string                 `json:"units"`    // units of positions
 }
 
 func (s *smoothMessage) bind(m *msgtype.Smooth) error {
-	if s.Addr == nil || s.Time == nil || s.Setpoint == nil {
-		return InvalidMessageError
+	var v validator
+	v.require("addr", s.Addr != nil)
+	v.require("time", s.Time != nil)
+	v.require("setpoint", s.Setpoint != nil)
+	if err := v.err(); err != nil {
+		return err
 	}
 
-	spvalue := *s.Setpoint
-	setpoint := make([]msgtype.SetpointValue, 1)
-	setpoint[0] = msgtype.SetpointValue{
-		Duration: spvalue[0],
-		Setpoint: spvalue[1],
+	if len(*s.Setpoint)/2 > msgtype.MaxSetpoints {
+		return InvalidSetpointError.withField("setpoint",
+			"at most %d setpoints, got %d", msgtype.MaxSetpoints, len(*s.Setpoint)/2)
+	}
+	var setpoint []msgtype.SetpointValue
+	if v.units("units", s.Units) {
+		setpoint = v.setpoints("setpoint", *s.Addr, *s.Setpoint, v1Units(s.Units))
+	}
+	if err := v.err(); err != nil {
+		return err
 	}
 
 	m.Addr = *s.Addr
@@ -35,13 +44,12 @@ func (s *smoothMessage) bind(m *msgtype.Smooth) error {
 
 func smoothHandler(w http.ResponseWriter, req *http.Request, body io.Reader) error {
 	if req.Method != "PUT" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
 		return MethodNotAllowed
 	}
 
 	var data smoothMessage
-	if err := json.NewDecoder(body).Decode(&data); err != nil {
-		return &Error{Message: err.Error()}
+	if err := decodeBody(body, &data); err != nil {
+		return err
 	}
 
 	var message msgtype.Smooth
@@ -49,7 +57,9 @@ func smoothHandler(w http.ResponseWriter, req *http.Request, body io.Reader) err
 		return err
 	}
 
-	QueueMessage(&message)
+	if err := command(req, &message); err != nil {
+		return err
+	}
 
 	io.WriteString(w, `{"ok":true}`)
 
@@ -0,0 +1,23 @@
+package cuddle
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+type statusResponse struct {
+	OK         bool             `json:"ok"`
+	Connection ConnectionStatus `json:"connection"`
+}
+
+func statusHandler(w http.ResponseWriter, req *http.Request, body io.Reader) error {
+	if req.Method != "GET" {
+		return MethodNotAllowed
+	}
+
+	return json.NewEncoder(w).Encode(&statusResponse{
+		OK:         true,
+		Connection: Connection(),
+	})
+}
@@ -0,0 +1,390 @@
+package cuddle
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"../msgtype"
+)
+
+// Actuator description and state.
+type actuatorResource struct {
+	msgtype.ActuatorInfo
+	Address  string       `json:"address"` // board address character
+	Limits   Limits       `json:"limits"`
+	Units    string       `json:"units"` // units of position and setpoint
+	Position *float64     `json:"position"`
+	Setpoint *float64     `json:"setpoint"`
+	Sleeping bool         `json:"sleeping"`
+	LastSeen *time.Time   `json:"last_seen"`
+	PID      *pidResource `json:"pid"` // last PID coefficients sent
+}
+
+// Changes to an actuator, applied in the order PID coefficients, then
+// setpoints, smooth motion or sleep.
+type actuatorUpdate struct {
+	PID       *pidResource       `json:"pid"`
+	Setpoints *setpointsResource `json:"setpoints"`
+	Smooth    *smoothResource    `json:"smooth"`
+	Sleep     bool               `json:"sleep"`
+}
+
+type pidResource struct {
+	Kp *float32 `json:"kp"`
+	Ki *float32 `json:"ki"`
+	Kd *float32 `json:"kd"`
+}
+
+type setpointsResource struct {
+	Delay     uint16             `json:"delay"`
+	Loop      uint16             `json:"loop"`
+	Units     string             `json:"units,omitempty"`
+	Setpoints []setpointResource `json:"setpoints"`
+}
+
+type smoothResource struct {
+	Interval  uint16             `json:"interval"` // time between setpoint updates in ms
+	Units     string             `json:"units,omitempty"`
+	Setpoints []setpointResource `json:"setpoints"`
+}
+
+type setpointResource struct {
+	Duration *uint16  `json:"duration"` // in ms
+	Position *float64 `json:"position"`
+}
+
+type actuatorListResponse struct {
+	OK        bool                `json:"ok"`
+	Actuators []*actuatorResource `json:"actuators"`
+}
+
+type actuatorResponse struct {
+	OK       bool              `json:"ok"`
+	Actuator *actuatorResource `json:"actuator"`
+}
+
+type pidResponse struct {
+	OK  bool         `json:"ok"`
+	PID *pidResource `json:"pid"`
+}
+
+type setpointsResponse struct {
+	OK        bool               `json:"ok"`
+	Setpoints *setpointsResource `json:"setpoints"`
+}
+
+type smoothResponse struct {
+	OK     bool            `json:"ok"`
+	Smooth *smoothResource `json:"smooth"`
+}
+
+type sleepResponse struct {
+	OK       bool `json:"ok"`
+	Sleeping bool `json:"sleeping"`
+}
+
+func listActuators(w http.ResponseWriter, req *http.Request) error {
+	units, err := queryUnits(req)
+	if err != nil {
+		return err
+	}
+
+	actuators := []*actuatorResource{}
+	for _, info := range msgtype.Actuators() {
+		actuators = append(actuators, newActuatorResource(info, units))
+	}
+
+	return json.NewEncoder(w).Encode(&actuatorListResponse{
+		OK:        true,
+		Actuators: actuators,
+	})
+}
+
+func getActuator(w http.ResponseWriter, req *http.Request, info msgtype.ActuatorInfo) error {
+	units, err := queryUnits(req)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(&actuatorResponse{
+		OK:       true,
+		Actuator: newActuatorResource(info, units),
+	})
+}
+
+func putActuator(w http.ResponseWriter, req *http.Request, body io.Reader, info msgtype.ActuatorInfo) error {
+	units, err := queryUnits(req)
+	if err != nil {
+		return err
+	}
+
+	var data actuatorUpdate
+	if err := decodeBody(body, &data); err != nil {
+		return err
+	}
+
+	// check every change before sending any
+	var v validator
+	var messages []encoding.BinaryMarshaler
+	if data.PID != nil {
+		messages = append(messages, data.PID.message(&v, "pid", info.Addr))
+	}
+
+	motions := 0
+	if data.Setpoints != nil {
+		messages = append(messages, data.Setpoints.message(&v, "setpoints", info.Addr, units))
+		motions++
+	}
+	if data.Smooth != nil {
+		messages = append(messages, data.Smooth.message(&v, "smooth", info.Addr, units))
+		motions++
+	}
+	if data.Sleep {
+		messages = append(messages, &msgtype.Sleep{info.Addr})
+		motions++
+	}
+	if motions > 1 {
+		v.add(InvalidMessageError.withDetail("only one of setpoints, smooth and sleep may be given"))
+	}
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	for _, m := range messages {
+		if err := command(req, m); err != nil {
+			return err
+		}
+	}
+
+	return json.NewEncoder(w).Encode(&actuatorResponse{
+		OK:       true,
+		Actuator: newActuatorResource(info, units),
+	})
+}
+
+func getSetpoints(w http.ResponseWriter, req *http.Request, info msgtype.ActuatorInfo) error {
+	units, err := queryUnits(req)
+	if err != nil {
+		return err
+	}
+
+	var setpoints *setpointsResource
+	if m := telemetry.get(info.Addr).setpoint; m != nil {
+		setpoints = newSetpointsResource(info, m, units)
+	}
+
+	return json.NewEncoder(w).Encode(&setpointsResponse{
+		OK:        true,
+		Setpoints: setpoints,
+	})
+}
+
+func putSetpoints(w http.ResponseWriter, req *http.Request, body io.Reader, info msgtype.ActuatorInfo) error {
+	units, err := queryUnits(req)
+	if err != nil {
+		return err
+	}
+
+	var data setpointsResource
+	if err := decodeBody(body, &data); err != nil {
+		return err
+	}
+
+	var v validator
+	m := data.message(&v, "", info.Addr, units)
+	if err := v.err(); err != nil {
+		return err
+	}
+	if err := command(req, m); err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(&setpointsResponse{
+		OK:        true,
+		Setpoints: newSetpointsResource(info, m, info.UnitsOf(bodyUnits(data.Units, units))),
+	})
+}
+
+func putSmooth(w http.ResponseWriter, req *http.Request, body io.Reader, info msgtype.ActuatorInfo) error {
+	units, err := queryUnits(req)
+	if err != nil {
+		return err
+	}
+
+	var data smoothResource
+	if err := decodeBody(body, &data); err != nil {
+		return err
+	}
+
+	var v validator
+	m := data.message(&v, "", info.Addr, units)
+	if err := v.err(); err != nil {
+		return err
+	}
+	if err := command(req, m); err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(&smoothResponse{
+		OK:     true,
+		Smooth: newSmoothResource(info, m, info.UnitsOf(bodyUnits(data.Units, units))),
+	})
+}
+
+func getPID(w http.ResponseWriter, req *http.Request, info msgtype.ActuatorInfo) error {
+	return json.NewEncoder(w).Encode(&pidResponse{
+		OK:  true,
+		PID: newPIDResource(telemetry.get(info.Addr).pid),
+	})
+}
+
+func putPID(w http.ResponseWriter, req *http.Request, body io.Reader, info msgtype.ActuatorInfo) error {
+	var data pidResource
+	if err := decodeBody(body, &data); err != nil {
+		return err
+	}
+
+	var v validator
+	m := data.message(&v, "", info.Addr)
+	if err := v.err(); err != nil {
+		return err
+	}
+	if err := command(req, m); err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(&pidResponse{
+		OK:  true,
+		PID: &data,
+	})
+}
+
+func getSleep(w http.ResponseWriter, req *http.Request, info msgtype.ActuatorInfo) error {
+	return json.NewEncoder(w).Encode(&sleepResponse{
+		OK:       true,
+		Sleeping: telemetry.get(info.Addr).sleeping,
+	})
+}
+
+func putSleep(w http.ResponseWriter, req *http.Request, info msgtype.ActuatorInfo) error {
+	if err := command(req, &msgtype.Sleep{info.Addr}); err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(&sleepResponse{
+		OK:       true,
+		Sleeping: true,
+	})
+}
+
+func newActuatorResource(info msgtype.ActuatorInfo, units string) *actuatorResource {
+	r := &actuatorResource{
+		ActuatorInfo: info,
+		Address:      string(rune(info.Addr)),
+		Limits:       GetLimits(info.Addr),
+		Units:        info.UnitsOf(units),
+		PID:          newPIDResource(telemetry.get(info.Addr).pid),
+	}
+	if data := telemetry.data([]msgtype.RemoteAddress{info.Addr}, time.Time{}, time.Time{}, units); len(data) > 0 {
+		r.Position = data[0].Position
+		r.Setpoint = data[0].Setpoint
+		r.Sleeping = data[0].Sleeping
+		r.LastSeen = data[0].LastSeen
+	}
+	return r
+}
+
+func newPIDResource(m *msgtype.SetPID) *pidResource {
+	if m == nil {
+		return nil
+	}
+	return &pidResource{&m.Kp, &m.Ki, &m.Kd}
+}
+
+func newSetpointsResource(info msgtype.ActuatorInfo, m *msgtype.Setpoint, units string) *setpointsResource {
+	return &setpointsResource{
+		Delay:     m.Delay,
+		Loop:      m.Loop,
+		Units:     info.UnitsOf(units),
+		Setpoints: newSetpointResources(info, m.Setpoints, units),
+	}
+}
+
+func newSmoothResource(info msgtype.ActuatorInfo, m *msgtype.Smooth, units string) *smoothResource {
+	return &smoothResource{
+		Interval:  m.Time,
+		Units:     info.UnitsOf(units),
+		Setpoints: newSetpointResources(info, m.Setpoint, units),
+	}
+}
+
+func newSetpointResources(info msgtype.ActuatorInfo, values []msgtype.SetpointValue, units string) []setpointResource {
+	r := make([]setpointResource, len(values))
+	for i, sp := range values {
+		d, p := sp.Duration, sp.Setpoint
+		r[i].Duration = &d
+		r[i].Position = decodePosition(info.Addr, &p, units)
+	}
+	return r
+}
+
+// Check the coefficients, which are all required, and make the message
+// setting them.
+func (p *pidResource) message(v *validator, field string, addr msgtype.RemoteAddress) *msgtype.SetPID {
+	v.require(join(field, "kp"), p.Kp != nil)
+	v.require(join(field, "ki"), p.Ki != nil)
+	v.require(join(field, "kd"), p.Kd != nil)
+	if p.Kp == nil || p.Ki == nil || p.Kd == nil {
+		return nil
+	}
+	return &msgtype.SetPID{addr, *p.Kp, *p.Ki, *p.Kd}
+}
+
+// Get the units given in the body, or otherwise those in the query string.
+func bodyUnits(body, query string) string {
+	if body != "" {
+		return body
+	}
+	return query
+}
+
+func (s *setpointsResource) message(v *validator, field string, addr msgtype.RemoteAddress, units string) *msgtype.Setpoint {
+	values := setpointValues(v, field, addr, s.Setpoints, bodyUnits(s.Units, units))
+	return &msgtype.Setpoint{addr, s.Delay, s.Loop, values}
+}
+
+func (s *smoothResource) message(v *validator, field string, addr msgtype.RemoteAddress, units string) *msgtype.Smooth {
+	if len(s.Setpoints) > msgtype.MaxSetpoints {
+		v.add(InvalidSetpointError.withField(join(field, "setpoints"),
+			"at most %d setpoints, got %d", msgtype.MaxSetpoints, len(s.Setpoints)))
+		return nil
+	}
+	values := setpointValues(v, field, addr, s.Setpoints, bodyUnits(s.Units, units))
+	return &msgtype.Smooth{addr, s.Interval, values}
+}
+
+// Check setpoints, converting them to values for the actuator.
+func setpointValues(v *validator, field string, addr msgtype.RemoteAddress, setpoints []setpointResource, units string) []msgtype.SetpointValue {
+	if !v.units(join(field, "units"), units) {
+		return nil
+	}
+	field = join(field, "setpoints")
+	if !v.require(field, len(setpoints) > 0) {
+		return nil
+	}
+
+	values := make([]msgtype.SetpointValue, len(setpoints))
+	for i, sp := range setpoints {
+		d := fmt.Sprintf("%s[%d].duration", field, i)
+		p := fmt.Sprintf("%s[%d].position", field, i)
+		hasDuration := v.require(d, sp.Duration != nil)
+		if v.require(p, sp.Position != nil) && hasDuration {
+			values[i] = v.setpoint(d, p, addr, float64(*sp.Duration), *sp.Position, units)
+		}
+	}
+	return values
+}
@@ -0,0 +1,114 @@
+package cuddle
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Prefix of the version 2 behaviour routes.
+const behavioursPath = "/2/behaviours"
+
+// Behaviours to start, blended where they share actuators.
+type behavioursRequest struct {
+	Resolution uint16          `json:"resolution"` // time between samples in ms, or 0 for the default
+	Behaviours []behaviourPart `json:"behaviours"`
+}
+
+// Parameters of a single behaviour to start.
+type behaviourParams struct {
+	Resolution uint16   `json:"resolution"`
+	Intensity  *float64 `json:"intensity"`
+	Rate       *float64 `json:"rate"`
+	Duration   *uint32  `json:"duration"`
+}
+
+type behaviourListResponse struct {
+	OK         bool             `json:"ok"`
+	Behaviours []*Behaviour     `json:"behaviours"`
+	Active     *activeBehaviour `json:"active"` // behaviours running, or null
+}
+
+type behaviourResponse struct {
+	OK        bool       `json:"ok"`
+	Behaviour *Behaviour `json:"behaviour"`
+}
+
+type activeBehaviourResponse struct {
+	OK     bool             `json:"ok"`
+	Active *activeBehaviour `json:"active"`
+}
+
+// Route requests under /2/behaviours:
+//
+//	GET, PUT, DELETE /2/behaviours
+//	GET, PUT, DELETE /2/behaviours/{name}
+func behavioursHandler(w http.ResponseWriter, req *http.Request, body io.Reader) error {
+	name := strings.Trim(strings.TrimPrefix(req.URL.Path, behavioursPath), "/")
+	if strings.Contains(name, "/") {
+		return NotFoundError.withDetail("%s", req.URL.Path)
+	}
+
+	var b *Behaviour
+	if name != "" {
+		var ok bool
+		if b, ok = LookupBehaviour(name); !ok {
+			return NotFoundError.withDetail("behaviour %q", name)
+		}
+	}
+
+	switch req.Method {
+	case "GET":
+		if b != nil {
+			return json.NewEncoder(w).Encode(&behaviourResponse{OK: true, Behaviour: b})
+		}
+		return json.NewEncoder(w).Encode(&behaviourListResponse{
+			OK:         true,
+			Behaviours: Behaviours(),
+			Active:     activeBehaviours(),
+		})
+	case "PUT":
+		if b != nil {
+			return putBehaviour(w, req, body, b)
+		}
+		return putBehaviours(w, req, body)
+	case "DELETE":
+		if err := stopBehaviours(req); err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(&activeBehaviourResponse{OK: true})
+	}
+	return MethodNotAllowed
+}
+
+func putBehaviour(w http.ResponseWriter, req *http.Request, body io.Reader, b *Behaviour) error {
+	var data behaviourParams
+	if err := decodeBody(body, &data); err != nil {
+		return err
+	}
+
+	parts := []behaviourPart{{b.Name, nil, data.Intensity, data.Rate, data.Duration}}
+	return startBehavioursResponse(w, req, "", parts, data.Resolution)
+}
+
+func putBehaviours(w http.ResponseWriter, req *http.Request, body io.Reader) error {
+	var data behavioursRequest
+	if err := decodeBody(body, &data); err != nil {
+		return err
+	}
+	if len(data.Behaviours) == 0 {
+		return missingField("behaviours")
+	}
+	return startBehavioursResponse(w, req, "behaviours", data.Behaviours, data.Resolution)
+}
+
+func startBehavioursResponse(w http.ResponseWriter, req *http.Request, field string, parts []behaviourPart, resolution uint16) error {
+	var v validator
+	a, err := startBehaviours(&v, field, req, parts, time.Duration(resolution)*time.Millisecond)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(&activeBehaviourResponse{OK: true, Active: a})
+}
@@ -0,0 +1,144 @@
+package cuddle
+
+import (
+	"encoding"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"../msgtype"
+)
+
+// Path of the version 2 replay route.
+const replayPath = "/2/replay"
+
+type replayResponse struct {
+	OK       bool    `json:"ok"`
+	Playing  bool    `json:"playing"`
+	Speed    float64 `json:"speed"`
+	Commands int     `json:"commands"` // number of commands in the session
+	Sent     int     `json:"sent"`     // number of commands sent so far
+	Duration uint64  `json:"duration"` // running time in ms at the replay speed
+}
+
+// The session last replayed.
+var replays = struct {
+	sync.Mutex
+	replayer *Replayer
+	speed    float64
+	stop     chan struct{}
+	playing  bool
+	asleep   bool // its actuators were put to sleep when it was stopped
+}{}
+
+var replayErr = log.New(logErr, "[replay] ", 0)
+
+// Route requests to /2/replay:
+//
+//	GET, PUT, DELETE /2/replay
+func replayHandler(w http.ResponseWriter, req *http.Request, body io.Reader) error {
+	switch req.Method {
+	case "GET":
+	case "PUT":
+		if err := startReplay(req, body); err != nil {
+			return err
+		}
+	case "DELETE":
+		if err := stopReplay(req, nil); err != nil {
+			return err
+		}
+	default:
+		return MethodNotAllowed
+	}
+
+	replays.Lock()
+	defer replays.Unlock()
+
+	r := &replayResponse{OK: true, Playing: replays.playing, Speed: replays.speed}
+	if p := replays.replayer; p != nil {
+		r.Commands = p.Len()
+		r.Sent = p.Sent()
+		r.Duration = uint64(p.Duration() / time.Millisecond)
+	}
+	return json.NewEncoder(w).Encode(r)
+}
+
+// Replay the session in the request body at the speed given by ?speed=,
+// replacing any replay running. Commands are sent on behalf of the client
+// making the request.
+func startReplay(req *http.Request, body io.Reader) error {
+	speed := 1.0
+	if s := req.URL.Query().Get("speed"); s != "" {
+		var err error
+		if speed, err = strconv.ParseFloat(s, 64); err != nil || speed <= 0 || speed > 100 {
+			return InvalidMessageError.withField("speed", "expected a factor between 0 and 100, got %q", s)
+		}
+	}
+
+	entries, err := ReadSession(body)
+	if err != nil {
+		return InvalidMessageError.withDetail("%s", err.Error())
+	}
+	p, err := NewReplayer(entries, speed, func(m encoding.BinaryMarshaler) error {
+		return command(req, m)
+	})
+	if err != nil {
+		return InvalidMessageError.withDetail("%s", err.Error())
+	} else if p.Len() == 0 {
+		return InvalidMessageError.withDetail("no commands to replay")
+	}
+
+	if err := stopReplay(req, p); err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	replays.Lock()
+	replays.replayer = p
+	replays.speed = speed
+	replays.stop = stop
+	replays.playing = true
+	replays.asleep = false
+	replays.Unlock()
+
+	go func() {
+		if err := p.Run(stop); err != nil {
+			replayErr.Printf("Failed to replay session %s", err.Error())
+		}
+		replays.Lock()
+		if replays.replayer == p {
+			replays.playing = false
+		}
+		replays.Unlock()
+	}()
+	return nil
+}
+
+// Stop the replay running, if any, and put the actuators the last replay
+// commanded to sleep, except those the next replay commands.
+func stopReplay(req *http.Request, next *Replayer) error {
+	replays.Lock()
+	p := replays.replayer
+	if replays.playing {
+		close(replays.stop)
+		replays.playing = false
+	}
+	asleep := replays.asleep
+	replays.asleep = true
+	replays.Unlock()
+
+	if p == nil || asleep {
+		return nil
+	}
+	var addrs []msgtype.RemoteAddress
+	for _, addr := range p.Actuators() {
+		if next == nil || !next.drives(addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	return sleepActuators(req, addrs)
+}
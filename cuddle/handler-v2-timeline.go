@@ -0,0 +1,202 @@
+package cuddle
+
+import (
+	"encoding"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"../msgtype"
+)
+
+// Prefix of the version 2 timeline routes.
+const timelinePath = "/2/timeline"
+
+type seekRequest struct {
+	Position *uint32 `json:"position"` // ms from the start of the timeline
+}
+
+type timelineResponse struct {
+	OK       bool     `json:"ok"`
+	State    string   `json:"state"`    // stopped, playing or paused
+	Position uint64   `json:"position"` // ms from the start of the timeline
+	Duration uint64   `json:"duration"` // running time in ms
+	Tracks   []string `json:"tracks"`   // names of the actuators moved
+}
+
+// Route requests under /2/timeline:
+//
+//	GET, PUT /2/timeline
+//	PUT      /2/timeline/play
+//	PUT      /2/timeline/pause
+//	PUT      /2/timeline/seek
+//	PUT      /2/timeline/stop
+func timelineHandler(w http.ResponseWriter, req *http.Request, body io.Reader) error {
+	action := strings.Trim(strings.TrimPrefix(req.URL.Path, timelinePath), "/")
+	switch action {
+	case "", "play", "pause", "seek", "stop":
+	default:
+		return NotFoundError.withDetail("%s", req.URL.Path)
+	}
+	if req.Method != "PUT" && (action != "" || req.Method != "GET") {
+		return MethodNotAllowed
+	}
+
+	var position time.Duration
+	switch {
+	case action == "" && req.Method == "PUT":
+		units, err := queryUnits(req)
+		if err != nil {
+			return err
+		}
+		var data timelineResource
+		if err := decodeBody(body, &data); err != nil {
+			return err
+		}
+		var v validator
+		tl := data.compile(&v, units)
+		if err := v.err(); err != nil {
+			return err
+		}
+		return loadTimeline(w, req, tl)
+
+	case action == "seek":
+		var data seekRequest
+		if err := decodeBody(body, &data); err != nil {
+			return err
+		} else if data.Position == nil {
+			return missingField("position")
+		}
+		position = time.Duration(*data.Position) * time.Millisecond
+	}
+
+	timelines.Lock()
+	defer timelines.Unlock()
+
+	if action == "" {
+		return json.NewEncoder(w).Encode(newTimelineResponse())
+	} else if timelines.current == nil {
+		return NotFoundError.withDetail("no timeline loaded")
+	}
+	send := func(m encoding.BinaryMarshaler) error {
+		return command(req, m)
+	}
+
+	var err error
+	switch action {
+	case "play":
+		err = playTimeline(send)
+	case "pause":
+		err = pauseTimeline(send)
+	case "seek":
+		err = seekTimeline(position, send)
+	case "stop":
+		err = stopTimeline(send)
+	}
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(newTimelineResponse())
+}
+
+// Replace the loaded timeline, stopping the one before it.
+func loadTimeline(w http.ResponseWriter, req *http.Request, tl *timeline) error {
+	timelines.Lock()
+	defer timelines.Unlock()
+
+	if timelines.current != nil {
+		err := stopTimeline(func(m encoding.BinaryMarshaler) error {
+			return command(req, m)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	timelines.current = tl
+	timelines.state = TimelineStopped
+	timelines.position = 0
+	return json.NewEncoder(w).Encode(newTimelineResponse())
+}
+
+// Play the loaded timeline from its position, or from the start if it has
+// finished.
+func playTimeline(send func(encoding.BinaryMarshaler) error) error {
+	state, position := timelineState(time.Now())
+	if state == TimelinePlaying {
+		return nil
+	}
+	if position >= timelines.current.duration {
+		position = 0
+	}
+	return playTimelineAt(position, send)
+}
+
+func playTimelineAt(position time.Duration, send func(encoding.BinaryMarshaler) error) error {
+	startedAt, err := timelines.current.play(position, send, time.Now)
+	if err != nil {
+		return err
+	}
+	timelines.state = TimelinePlaying
+	timelines.startedAt = startedAt
+	return nil
+}
+
+// Pause the loaded timeline, holding each actuator where it is.
+func pauseTimeline(send func(encoding.BinaryMarshaler) error) error {
+	state, position := timelineState(time.Now())
+	if state != TimelinePlaying {
+		return nil
+	}
+	for _, t := range timelines.current.tracks {
+		if err := send(t.holdAt(position)); err != nil {
+			return err
+		}
+	}
+	timelines.state = TimelinePaused
+	timelines.position = position
+	return nil
+}
+
+// Move the loaded timeline to a position, carrying on from there if it is
+// playing.
+func seekTimeline(position time.Duration, send func(encoding.BinaryMarshaler) error) error {
+	if position > timelines.current.duration {
+		return InvalidMessageError.withField("position", "beyond the end of the timeline at %d ms",
+			timelines.current.duration/time.Millisecond)
+	}
+	if state, _ := timelineState(time.Now()); state == TimelinePlaying {
+		return playTimelineAt(position, send)
+	}
+	timelines.position = position
+	return nil
+}
+
+// Stop the loaded timeline, putting its actuators to sleep, and go back to
+// the start.
+func stopTimeline(send func(encoding.BinaryMarshaler) error) error {
+	timelines.state = TimelineStopped
+	timelines.position = 0
+	for _, t := range timelines.current.tracks {
+		if err := send(&msgtype.Sleep{t.info.Addr}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newTimelineResponse() *timelineResponse {
+	r := &timelineResponse{OK: true, State: TimelineStopped, Tracks: []string{}}
+	if timelines.current == nil {
+		return r
+	}
+	state, position := timelineState(time.Now())
+	r.State = state
+	r.Position = uint64(position / time.Millisecond)
+	r.Duration = uint64(timelines.current.duration / time.Millisecond)
+	for _, t := range timelines.current.tracks {
+		r.Tracks = append(r.Tracks, t.info.Name)
+	}
+	return r
+}
@@ -0,0 +1,125 @@
+package cuddle
+
+import (
+	"encoding"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"../msgtype"
+	"../trajectory"
+)
+
+// Motion generated from a trajectory.
+type trajectoryResource struct {
+	Units      string           `json:"units,omitempty"`
+	Resolution uint16           `json:"resolution"` // time between samples in ms, or 0 for the default
+	Delay      uint16           `json:"delay"`      // time before starting in ms
+	Smooth     bool             `json:"smooth"`     // send as smooth motion
+	Trajectory *trajectory.Spec `json:"trajectory"`
+}
+
+type trajectoryResponse struct {
+	OK        bool   `json:"ok"`
+	Setpoints int    `json:"setpoints"` // number of setpoints sent
+	Duration  uint64 `json:"duration"`  // running time of one cycle in ms
+	Forever   bool   `json:"forever"`   // repeats until stopped
+}
+
+func putTrajectory(w http.ResponseWriter, req *http.Request, body io.Reader, info msgtype.ActuatorInfo) error {
+	units, err := queryUnits(req)
+	if err != nil {
+		return err
+	}
+
+	var data trajectoryResource
+	if err := decodeBody(body, &data); err != nil {
+		return err
+	}
+
+	var v validator
+	m, values := data.message(&v, "", info.Addr, units)
+	if err := v.err(); err != nil {
+		return err
+	}
+	if err := command(req, m); err != nil {
+		return err
+	}
+
+	var d time.Duration
+	for _, sp := range values {
+		d += time.Duration(sp.Duration) * time.Millisecond
+	}
+	return json.NewEncoder(w).Encode(&trajectoryResponse{
+		OK:        true,
+		Setpoints: len(values),
+		Duration:  uint64(d / time.Millisecond),
+		Forever:   data.Trajectory.Forever(),
+	})
+}
+
+// Sample the trajectory, making a setpoint message, which loops if the
+// trajectory repeats forever, or a smooth message.
+func (r *trajectoryResource) message(v *validator, field string, addr msgtype.RemoteAddress, units string) (encoding.BinaryMarshaler, []msgtype.SetpointValue) {
+	if r.Units != "" {
+		units = r.Units
+	}
+	if !v.units(join(field, "units"), units) || !v.require(join(field, "trajectory"), r.Trajectory != nil) {
+		return nil, nil
+	}
+	path := join(field, "trajectory")
+
+	// moves start from the last position read, if not given
+	spec := *r.Trajectory
+	switch spec.Type {
+	case trajectory.TypeLinear, trajectory.TypeMinJerk:
+		if spec.From == nil {
+			p, ok := telemetry.position(addr)
+			if !ok {
+				v.add(missingField(join(path, "from")))
+				return nil, nil
+			}
+			spec.From = decodePosition(addr, &p, units)
+		}
+	}
+
+	tr, err := spec.Trajectory()
+	if e, ok := err.(*trajectory.FieldError); ok {
+		v.add(InvalidMessageError.withField(join(path, e.Field), "%s", e.Message))
+		return nil, nil
+	} else if err != nil {
+		v.add(InvalidMessageError.withField(path, "%s", err.Error()))
+		return nil, nil
+	}
+
+	resolution := time.Duration(r.Resolution) * time.Millisecond
+	values, err := trajectory.Sample(tr, resolution, addr, units)
+	switch err {
+	case nil:
+	case msgtype.InvalidPositionError:
+		v.add(InvalidSetpointError.withField(path, "positions out of range"))
+		return nil, nil
+	default:
+		v.add(InvalidMessageError.withField(path, "%s", err.Error()))
+		return nil, nil
+	}
+
+	if !r.Smooth {
+		loop := uint16(0)
+		if spec.Forever() {
+			loop = msgtype.LOOP_INFINITE
+		}
+		return &msgtype.Setpoint{addr, r.Delay, loop, values}, values
+	}
+
+	if spec.Forever() {
+		v.add(InvalidMessageError.withField(join(path, "cycles"), "smooth motion cannot repeat forever"))
+	} else if r.Delay != 0 {
+		v.add(InvalidMessageError.withField(join(field, "delay"), "smooth motion cannot be delayed"))
+	} else if len(values) > msgtype.MaxSetpoints {
+		v.add(InvalidSetpointError.withField(path,
+			"at most %d setpoints, got %d", msgtype.MaxSetpoints, len(values)))
+	}
+	return &msgtype.Smooth{addr, 0, values}, values
+}
@@ -0,0 +1,79 @@
+package cuddle
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"../msgtype"
+)
+
+// Prefix of the version 2 actuator routes.
+const actuatorsPath = "/2/actuators"
+
+// Route requests under /2/actuators:
+//
+//	GET      /2/actuators
+//	GET, PUT /2/actuators/{name}
+//	GET, PUT /2/actuators/{name}/setpoints
+//	PUT      /2/actuators/{name}/smooth
+//	PUT      /2/actuators/{name}/trajectory
+//	GET, PUT /2/actuators/{name}/pid
+//	GET, PUT /2/actuators/{name}/sleep
+func actuatorsV2Handler(w http.ResponseWriter, req *http.Request, body io.Reader) error {
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, actuatorsPath), "/")
+	if path == "" {
+		if req.Method != "GET" {
+			return MethodNotAllowed
+		}
+		return listActuators(w, req)
+	}
+
+	parts := strings.Split(path, "/")
+	info, ok := msgtype.LookupName(parts[0])
+	if !ok {
+		return NotFoundError.withDetail("actuator %q", parts[0])
+	}
+
+	resource := ""
+	if len(parts) == 2 {
+		resource = parts[1]
+	} else if len(parts) > 2 {
+		return NotFoundError.withDetail("%s", req.URL.Path)
+	}
+
+	switch resource + " " + req.Method {
+	case " GET":
+		return getActuator(w, req, info)
+	case " PUT":
+		return putActuator(w, req, body, info)
+	case "setpoints GET":
+		return getSetpoints(w, req, info)
+	case "setpoints PUT":
+		return putSetpoints(w, req, body, info)
+	case "smooth PUT":
+		return putSmooth(w, req, body, info)
+	case "trajectory PUT":
+		return putTrajectory(w, req, body, info)
+	case "pid GET":
+		return getPID(w, req, info)
+	case "pid PUT":
+		return putPID(w, req, body, info)
+	case "sleep GET":
+		return getSleep(w, req, info)
+	case "sleep PUT":
+		return putSleep(w, req, info)
+	}
+
+	switch resource {
+	case "", "setpoints", "smooth", "trajectory", "pid", "sleep":
+		return MethodNotAllowed
+	}
+	return NotFoundError.withDetail("%s", req.URL.Path)
+}
+
+// Get the units requested in the query string.
+func queryUnits(req *http.Request) (string, error) {
+	units := req.URL.Query().Get("units")
+	return units, checkUnits(units)
+}
@@ -0,0 +1,113 @@
+package cuddle
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"../sim"
+)
+
+func TestActuatorsV2(t *testing.T) {
+	robot, port := Pipe()
+	go sim.New().Serve(robot)
+
+	// the handlers send through the default transport
+	tr := NewTransport()
+	saved := DefaultTransport
+	DefaultTransport = tr
+	done := make(chan error)
+	go func() { done <- tr.Run(port) }()
+	defer func() {
+		port.Close()
+		<-done
+		DefaultTransport = saved
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(actuatorsPath, makeHandler(actuatorsV2Handler))
+	mux.HandleFunc(actuatorsPath+"/", makeHandler(actuatorsV2Handler))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	do := func(method, path, body string, status int) map[string]interface{} {
+		req, err := http.NewRequest(method, server.URL+path, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var data map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		if resp.StatusCode != status {
+			t.Fatalf("%s %s: expected status %d, got %d %v", method, path, status, resp.StatusCode, data)
+		}
+		return data
+	}
+
+	if data := do("GET", "/2/actuators", "", 200); len(data["actuators"].([]interface{})) != 5 {
+		t.Fatalf("expected 5 actuators, got %v", data["actuators"])
+	}
+
+	do("PUT", "/2/actuators/ribs/pid", `{"kp": 10, "ki": 0, "kd": 0.5}`, 200)
+	if data := do("GET", "/2/actuators/ribs/pid", "", 200); data["pid"].(map[string]interface{})["kd"] != 0.5 {
+		t.Fatalf("unexpected pid %v", data["pid"])
+	}
+
+	do("PUT", "/2/actuators/ribs/setpoints",
+		`{"units": "deg", "setpoints": [{"duration": 1000, "position": 90}]}`, 200)
+	data := do("GET", "/2/actuators/ribs/setpoints?units=raw", "", 200)
+	setpoints := data["setpoints"].(map[string]interface{})["setpoints"].([]interface{})
+	if p := setpoints[0].(map[string]interface{})["position"]; p != 16384.0 {
+		t.Fatalf("expected position 16384, got %v", p)
+	}
+
+	data = do("PUT", "/2/actuators/spine/smooth", `{"interval": 10, "setpoints": [
+		{"duration": 500, "position": 1000}, {"duration": 500, "position": 2000}]}`, 200)
+	smooth := data["smooth"].(map[string]interface{})
+	if smooth["interval"] != 10.0 || len(smooth["setpoints"].([]interface{})) != 2 {
+		t.Fatalf("unexpected smooth %v", smooth)
+	}
+
+	data = do("PUT", "/2/actuators/ribs/trajectory",
+		`{"units": "deg", "trajectory": {"type": "breathe", "amplitude": 20, "rate": 12}}`, 200)
+	if data["setpoints"] != 100.0 || data["duration"] != 5000.0 || data["forever"] != true {
+		t.Fatalf("unexpected trajectory %v", data)
+	}
+	if data := do("PUT", "/2/actuators/ribs/trajectory", `{"trajectory": {"type": "zigzag"}}`, 400); data["field"] != "trajectory.type" {
+		t.Fatalf("unexpected error %v", data)
+	}
+	if data := do("PUT", "/2/actuators/ribs/trajectory",
+		`{"smooth": true, "delay": 10, "trajectory": {"type": "sine", "amplitude": 1, "period": 100, "cycles": 1}}`, 400); data["field"] != "delay" {
+		t.Fatalf("unexpected error %v", data)
+	}
+
+	do("PUT", "/2/actuators/ribs", `{"sleep": true}`, 200)
+	if data := do("GET", "/2/actuators/ribs/sleep", "", 200); data["sleeping"] != true {
+		t.Fatal("ribs not sleeping")
+	}
+
+	// errors
+	if data := do("PUT", "/2/actuators/ribs/pid", `{"kp": 10}`, 400); data["code"] != "missing_field" || data["field"] != "ki" {
+		t.Fatalf("unexpected error %v", data)
+	}
+	if data := do("PUT", "/2/actuators/ribs/pid", `{"kp": "ten"}`, 400); data["code"] != "invalid_message" || data["field"] != "kp" {
+		t.Fatalf("unexpected error %v", data)
+	}
+	do("PUT", "/2/actuators/ribs/setpoints", `{"setpoints": [{"duration": 1000}]}`, 400)
+	do("PUT", "/2/actuators/ribs/setpoints", `{"units": "furlongs", "setpoints": [{"duration": 1, "position": 1}]}`, 400)
+	do("PUT", "/2/actuators/ribs", `{"sleep": true, "setpoints": {"setpoints": [{"duration": 1, "position": 1}]}}`, 400)
+	do("PUT", "/2/actuators/ribs/pid", `{"kp": `, 400)
+	do("GET", "/2/actuators/tail", "", 404)
+	do("GET", "/2/actuators/ribs/wag", "", 404)
+	do("DELETE", "/2/actuators/ribs", "", 405)
+	do("PUT", "/2/actuators/ribs/smooth", "", 400)
+}
@@ -0,0 +1,241 @@
+package cuddle
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"../msgtype"
+)
+
+// Default time a lease lasts without renewal.
+var DefaultLeaseTimeout = 5 * time.Second
+
+// Header identifying the lease under which a command is sent.
+const LeaseHeader = "X-Cuddle-Lease"
+
+// Number of expired leases kept for reporting.
+const expiredLeaseHistory = 32
+
+// Lease held by a client driving actuators. If the lease is not renewed
+// before it expires, the actuators it drove are put to sleep.
+type Lease struct {
+	ID        string                  `json:"id"`
+	Client    string                  `json:"client,omitempty"`
+	Timeout   int64                   `json:"timeout"` // in ms
+	Renewed   time.Time               `json:"renewed"`
+	Expires   time.Time               `json:"expires"`
+	Expired   bool                    `json:"expired"`
+	ExpiredAt *time.Time              `json:"expired_at,omitempty"`
+	Addrs     []msgtype.RemoteAddress `json:"addrs"` // actuators driven
+}
+
+var leases = struct {
+	sync.Mutex
+	m       map[string]*Lease
+	expired []*Lease
+	watch   sync.Once
+}{m: make(map[string]*Lease)}
+
+var leaseErr = log.New(logErr, "[lease] ", 0)
+
+// Create a new lease, starting the watchdog if needed.
+func createLease(client string, timeout time.Duration) (*Lease, error) {
+	if timeout <= 0 {
+		timeout = DefaultLeaseTimeout
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	l := &Lease{
+		ID:      hex.EncodeToString(b),
+		Client:  client,
+		Timeout: int64(timeout / time.Millisecond),
+		Renewed: now,
+		Expires: now.Add(timeout),
+	}
+
+	leases.Lock()
+	leases.m[l.ID] = l
+	leases.Unlock()
+
+	leases.watch.Do(func() {
+		go watchLeases()
+	})
+
+	return leaseCopy(l), nil
+}
+
+// Renew a lease, returning a copy of it.
+func renewLease(id string) (*Lease, error) {
+	leases.Lock()
+	defer leases.Unlock()
+
+	l, err := findLease(id)
+	if err != nil {
+		return nil, err
+	}
+	if l.Expired {
+		return leaseCopy(l), LeaseExpiredError.withDetail("lease %s", id)
+	}
+
+	l.Renewed = time.Now()
+	l.Expires = l.Renewed.Add(time.Duration(l.Timeout) * time.Millisecond)
+	return leaseCopy(l), nil
+}
+
+// Release a lease without putting its actuators to sleep.
+func releaseLease(id string) error {
+	leases.Lock()
+	defer leases.Unlock()
+
+	if _, err := findLease(id); err != nil {
+		return err
+	}
+	delete(leases.m, id)
+	return nil
+}
+
+// Get a copy of a lease.
+func getLease(id string) (*Lease, error) {
+	leases.Lock()
+	defer leases.Unlock()
+
+	l, err := findLease(id)
+	if err != nil {
+		return nil, err
+	}
+	return leaseCopy(l), nil
+}
+
+// Get copies of all active and recently expired leases.
+func listLeases() []*Lease {
+	leases.Lock()
+	defer leases.Unlock()
+
+	list := make([]*Lease, 0, len(leases.m)+len(leases.expired))
+	for _, l := range leases.m {
+		list = append(list, leaseCopy(l))
+	}
+	for _, l := range leases.expired {
+		list = append(list, leaseCopy(l))
+	}
+	return list
+}
+
+// Renew the lease named in a request, if any, before sending a command.
+// Returns an error if the lease is unknown or has expired.
+func requestLease(req *http.Request) (string, error) {
+	id := req.Header.Get(LeaseHeader)
+	if id == "" {
+		return "", nil
+	}
+	if _, err := renewLease(id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Record that a lease, or no lease if id is empty, drives an actuator,
+// handing it over from any lease that drove it before. Returns a function
+// that undoes the handover, should the command fail.
+func driveLease(id string, addr msgtype.RemoteAddress) (undo func()) {
+	leases.Lock()
+	defer leases.Unlock()
+
+	var prev *Lease
+	for _, l := range leases.m {
+		if containsAddress(l.Addrs, addr) {
+			prev = l
+		}
+	}
+	next := leases.m[id]
+	if next == prev {
+		return func() {}
+	}
+	if prev != nil {
+		prev.Addrs = removeAddress(prev.Addrs, addr)
+	}
+	if next != nil {
+		next.Addrs = append(next.Addrs, addr)
+	}
+
+	return func() {
+		leases.Lock()
+		defer leases.Unlock()
+
+		if next != nil {
+			next.Addrs = removeAddress(next.Addrs, addr)
+		}
+		if prev != nil && !prev.Expired && !containsAddress(prev.Addrs, addr) {
+			prev.Addrs = append(prev.Addrs, addr)
+		}
+	}
+}
+
+// Find a lease by ID. Must be called with the lock held.
+func findLease(id string) (*Lease, error) {
+	if l, ok := leases.m[id]; ok {
+		return l, nil
+	}
+	for _, l := range leases.expired {
+		if l.ID == id {
+			return l, nil
+		}
+	}
+	return nil, InvalidLeaseError.withDetail("lease %q", id)
+}
+
+// Put actuators to sleep when their leases expire.
+func watchLeases() {
+	for now := range time.Tick(100 * time.Millisecond) {
+		leases.Lock()
+		var expired []*Lease
+		for id, l := range leases.m {
+			if now.After(l.Expires) {
+				l.Expired = true
+				l.ExpiredAt = &now
+				expired = append(expired, leaseCopy(l))
+				delete(leases.m, id)
+				leases.expired = append(leases.expired, l)
+			}
+		}
+		if n := len(leases.expired); n > expiredLeaseHistory {
+			leases.expired = leases.expired[n-expiredLeaseHistory:]
+		}
+		leases.Unlock()
+
+		for _, l := range expired {
+			leaseErr.Printf("Lease %s for %q expired, sleeping %v", l.ID, l.Client, l.Addrs)
+			for _, addr := range l.Addrs {
+				go func(addr msgtype.RemoteAddress) {
+					if err := Send(&msgtype.Sleep{addr}); err != nil {
+						leaseErr.Printf("Failed to sleep %d %s", addr, err.Error())
+					}
+				}(addr)
+			}
+		}
+	}
+}
+
+func removeAddress(addrs []msgtype.RemoteAddress, addr msgtype.RemoteAddress) []msgtype.RemoteAddress {
+	for i, a := range addrs {
+		if a == addr {
+			return append(addrs[:i:i], addrs[i+1:]...)
+		}
+	}
+	return addrs
+}
+
+func leaseCopy(l *Lease) *Lease {
+	c := *l
+	c.Addrs = append([]msgtype.RemoteAddress(nil), l.Addrs...)
+	return &c
+}
@@ -0,0 +1,48 @@
+package cuddle
+
+import (
+	"testing"
+	"time"
+
+	"../msgtype"
+)
+
+func TestLeaseHandover(t *testing.T) {
+	a, _ := createLease("a", time.Minute)
+	b, _ := createLease("b", time.Minute)
+	defer releaseLease(a.ID)
+	defer releaseLease(b.ID)
+
+	addrs := func(id string) []msgtype.RemoteAddress {
+		l, err := getLease(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l.Addrs
+	}
+
+	driveLease(a.ID, msgtype.RibsAddress)
+	driveLease(a.ID, msgtype.RibsAddress)
+	if got := addrs(a.ID); len(got) != 1 {
+		t.Fatalf("expected lease a to drive the ribs once, got %v", got)
+	}
+
+	// another lease takes over, then fails and gives the ribs back
+	undo := driveLease(b.ID, msgtype.RibsAddress)
+	if got := addrs(a.ID); len(got) != 0 {
+		t.Fatalf("expected lease a to hand over the ribs, got %v", got)
+	}
+	if got := addrs(b.ID); len(got) != 1 || got[0] != msgtype.RibsAddress {
+		t.Fatalf("expected lease b to drive the ribs, got %v", got)
+	}
+	undo()
+	if got := addrs(a.ID); len(got) != 1 || len(addrs(b.ID)) != 0 {
+		t.Fatalf("expected the ribs back with lease a, got %v", got)
+	}
+
+	// commands without a lease take over too
+	driveLease("", msgtype.RibsAddress)
+	if got := addrs(a.ID); len(got) != 0 {
+		t.Fatalf("expected lease a to hand over the ribs, got %v", got)
+	}
+}
@@ -0,0 +1,118 @@
+package cuddle
+
+import (
+	"encoding"
+	"encoding/json"
+	"io"
+	"math"
+	"sync"
+
+	"../msgtype"
+)
+
+// Soft limits for an actuator.
+type Limits struct {
+	Min         uint16  `json:"min" toml:"min"`                   // minimum position
+	Max         uint16  `json:"max" toml:"max"`                   // maximum position
+	MaxSlew     float64 `json:"max_slew" toml:"max_slew"`         // maximum speed in (1 / 2^16) circles per second, or 0 for none
+	MinDuration uint16  `json:"min_duration" toml:"min_duration"` // minimum setpoint duration in ms
+}
+
+// Limits allowing any setpoint.
+var NoLimits = Limits{Max: 0xffff}
+
+var limits = struct {
+	sync.Mutex
+	m map[msgtype.RemoteAddress]Limits
+}{m: make(map[msgtype.RemoteAddress]Limits)}
+
+// Set the limits for an actuator.
+func SetLimits(addr msgtype.RemoteAddress, l Limits) {
+	limits.Lock()
+	defer limits.Unlock()
+	limits.m[addr] = l
+}
+
+// Get the limits for an actuator.
+func GetLimits(addr msgtype.RemoteAddress) Limits {
+	limits.Lock()
+	defer limits.Unlock()
+	if l, ok := limits.m[addr]; ok {
+		return l
+	}
+	return NoLimits
+}
+
+// Load limits from a JSON object keyed by actuator name. Fields that are not
+// given are not limited.
+func LoadLimits(r io.Reader) error {
+	var data map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return err
+	}
+
+	loaded := make(map[msgtype.RemoteAddress]Limits)
+	for name, raw := range data {
+		var addr msgtype.RemoteAddress
+		if err := addr.UnmarshalText([]byte(name)); err != nil {
+			return InvalidAddressError.withDetail("%q", name)
+		}
+		l := NoLimits
+		if err := json.Unmarshal(raw, &l); err != nil {
+			return err
+		}
+		if l.Min > l.Max {
+			return InvalidSetpointError.withDetail("%s: min %d > max %d", name, l.Min, l.Max)
+		}
+		loaded[addr] = l
+	}
+
+	for addr, l := range loaded {
+		SetLimits(addr, l)
+	}
+
+	return nil
+}
+
+// Check setpoint and smooth messages against the actuator limits. The first
+// setpoint is checked against the last position reading, if any.
+func CheckLimits(message encoding.BinaryMarshaler) error {
+	switch m := message.(type) {
+	case *msgtype.Setpoint:
+		values := m.Setpoints
+		// looping setpoints also move from the last to the first
+		if m.Loop != 0 && len(values) > 1 {
+			values = append(values[:len(values):len(values)], values[0])
+		}
+		return GetLimits(m.Addr).check(m.Addr, values)
+	case *msgtype.Smooth:
+		return GetLimits(m.Addr).check(m.Addr, m.Setpoint)
+	}
+	return nil
+}
+
+func (l Limits) check(addr msgtype.RemoteAddress, values []msgtype.SetpointValue) error {
+	from, known := telemetry.position(addr)
+
+	for i, v := range values {
+		if v.Setpoint < l.Min || v.Setpoint > l.Max {
+			return InvalidSetpointError.withDetail(
+				"setpoint %d: position %d outside %d..%d", i, v.Setpoint, l.Min, l.Max)
+		}
+		if v.Duration < l.MinDuration {
+			return InvalidSetpointError.withDetail(
+				"setpoint %d: duration %dms less than %dms", i, v.Duration, l.MinDuration)
+		}
+		if l.MaxSlew > 0 && known {
+			delta := math.Abs(float64(v.Setpoint) - float64(from))
+			if speed := delta * 1000 / float64(v.Duration); speed > l.MaxSlew {
+				return InvalidSetpointError.withDetail(
+					"setpoint %d: moving %d to %d in %dms exceeds %g per second",
+					i, from, v.Setpoint, v.Duration, l.MaxSlew)
+			}
+		}
+		from, known = v.Setpoint, true
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package cuddle
+
+import (
+	"bytes"
+	"testing"
+
+	"../msgtype"
+)
+
+func TestLimits(t *testing.T) {
+	defer SetLimits(msgtype.HeadYAddress, NoLimits)
+
+	err := LoadLimits(bytes.NewBufferString(
+		`{"heady": {"min": 1000, "max": 9000, "max_slew": 8000, "min_duration": 20}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		values []msgtype.SetpointValue
+		ok     bool
+	}{
+		{[]msgtype.SetpointValue{{Duration: 1000, Setpoint: 5000}}, true},
+		{[]msgtype.SetpointValue{{Duration: 1000, Setpoint: 500}}, false},
+		{[]msgtype.SetpointValue{{Duration: 1000, Setpoint: 9500}}, false},
+		{[]msgtype.SetpointValue{{Duration: 10, Setpoint: 5000}}, false},
+		{[]msgtype.SetpointValue{{Duration: 100, Setpoint: 1000}, {Duration: 100, Setpoint: 9000}}, false},
+		{[]msgtype.SetpointValue{{Duration: 1000, Setpoint: 1000}, {Duration: 1000, Setpoint: 9000}}, true},
+	} {
+		err := CheckLimits(&msgtype.Setpoint{msgtype.HeadYAddress, 0, 0, c.values})
+		if c.ok && err != nil {
+			t.Fatalf("%v: %v", c.values, err)
+		} else if !c.ok && (err == nil || err.(*Error).Message != InvalidSetpointError.Message) {
+			t.Fatalf("%v: expected %v, got %v", c.values, InvalidSetpointError, err)
+		}
+	}
+
+	// looping back to the start is too fast
+	err = CheckLimits(&msgtype.Setpoint{msgtype.HeadYAddress, 0, msgtype.LOOP_INFINITE, []msgtype.SetpointValue{
+		{Duration: 100, Setpoint: 1000}, {Duration: 1000, Setpoint: 9000},
+	}})
+	if err == nil {
+		t.Fatal("Expected slew rate error looping setpoints")
+	}
+
+	if err := LoadLimits(bytes.NewBufferString(`{"heady": {"min": 2, "max": 1}}`)); err == nil {
+		t.Fatal("Expected error for min > max")
+	}
+}
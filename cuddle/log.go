@@ -0,0 +1,75 @@
+package cuddle
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// Log output that can be redirected while running.
+type logWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+var logOut = &logWriter{w: os.Stdout}
+var logErr = &logWriter{w: os.Stderr}
+
+// Writers for log output and errors, redirected by SetLogFile.
+var LogOut, LogErr io.Writer = logOut, logErr
+
+var logFile = struct {
+	sync.Mutex
+	f *os.File
+}{}
+
+// Send log output to a file, or to stdout and stderr if the name is empty.
+// Setting the same name again reopens the file, so that it may be rotated.
+func SetLogFile(name string) error {
+	f, err := openLogFile(name)
+	if err != nil {
+		return err
+	}
+	useLogFile(f)
+	return nil
+}
+
+// Open a file for appending, or return nil if the name is empty.
+func openLogFile(name string) (*os.File, error) {
+	if name == "" {
+		return nil, nil
+	}
+	return os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+}
+
+// Send log output to an open file, or to stdout and stderr if nil, closing
+// the file used before.
+func useLogFile(f *os.File) {
+	logFile.Lock()
+	defer logFile.Unlock()
+
+	if f != nil {
+		logOut.set(f)
+		logErr.set(f)
+	} else {
+		logOut.set(os.Stdout)
+		logErr.set(os.Stderr)
+	}
+
+	if logFile.f != nil {
+		logFile.f.Close()
+	}
+	logFile.f = f
+}
+
+func (l *logWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+func (l *logWriter) set(w io.Writer) {
+	l.mu.Lock()
+	l.w = w
+	l.mu.Unlock()
+}
@@ -0,0 +1,155 @@
+package cuddle
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// http://projectgus.com/2011/10/notes-on-ftdi-latency-with-arduino/
+// http://faumarz.blogspot.ca/2014/06/change-ftdi-usb-serial-latency-in-linux.html
+// https://forum.openwrt.org/viewtopic.php?id=47367
+/*
+
+   The termios settings below are equivalent to:
+
+   # stty -F /dev/ttyUSB0 115200 raw
+   # setserial /dev/ttyUSB0 low_latency
+
+   The FTDI latency timer is not configured here:
+
+   # echo 1 > /sys/bus/usb-serial/devices/ttyUSB0/latency_timer
+
+*/
+
+const (
+	kCBAUD           = 0010017 // baud rate bits in c_cflag
+	kASYNCLowLatency = 1 << 13 // ASYNC_LOW_LATENCY in serial_struct flags
+)
+
+// Supported baud rates.
+var baudRates = map[int]uint32{
+	9600:   syscall.B9600,
+	19200:  syscall.B19200,
+	38400:  syscall.B38400,
+	57600:  syscall.B57600,
+	115200: syscall.B115200,
+	230400: syscall.B230400,
+	460800: syscall.B460800,
+	500000: syscall.B500000,
+	921600: syscall.B921600,
+}
+
+// struct serial_struct from linux/serial.h
+type serialStruct struct {
+	Type          int32
+	Line          int32
+	Port          uint32
+	IRQ           int32
+	Flags         int32
+	XmitFifoSize  int32
+	CustomDivisor int32
+	BaudBase      int32
+	CloseDelay    uint16
+	IOType        uint8
+	ReservedChar  uint8
+	Hub6          int32
+	ClosingWait   uint16
+	ClosingWait2  uint16
+	IOMemBase     uintptr
+	IOMemRegShift uint16
+	PortHigh      uint32
+	IOMapBase     uintptr
+}
+
+func openSerial(name string, c *PortConfig) (Port, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|syscall.O_NOCTTY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := configurePort(f, c); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Configure a serial port with raw mode, baud rate and latency settings.
+func configurePort(f *os.File, c *PortConfig) error {
+	speed, ok := baudRates[c.Baud]
+	if !ok {
+		return fmt.Errorf("%s: unsupported baud rate %d", f.Name(), c.Baud)
+	}
+
+	var t syscall.Termios
+	if err := ioctl(f, syscall.TCGETS, unsafe.Pointer(&t)); err != nil {
+		return &os.PathError{Op: "TCGETS", Path: f.Name(), Err: err}
+	}
+
+	// equivalent to cfmakeraw()
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK |
+		syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL |
+		syscall.IXON
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON |
+		syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB
+	t.Cflag |= syscall.CS8 | syscall.CLOCAL | syscall.CREAD
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+
+	// equivalent to cfsetspeed()
+	t.Cflag &^= kCBAUD
+	t.Cflag |= speed
+	t.Ispeed = speed
+	t.Ospeed = speed
+
+	if err := ioctl(f, syscall.TCSETS, unsafe.Pointer(&t)); err != nil {
+		return &os.PathError{Op: "TCSETS", Path: f.Name(), Err: err}
+	}
+
+	if c.LowLatency {
+		return setLowLatency(f)
+	}
+
+	return nil
+}
+
+// Set ASYNC_LOW_LATENCY on the serial driver. Devices that are not serial
+// ports, such as pseudo-terminals, are left unchanged.
+func setLowLatency(f *os.File) error {
+	var s serialStruct
+	if err := ioctl(f, syscall.TIOCGSERIAL, unsafe.Pointer(&s)); err == syscall.ENOTTY || err == syscall.EINVAL {
+		return nil
+	} else if err != nil {
+		return &os.PathError{Op: "TIOCGSERIAL", Path: f.Name(), Err: err}
+	}
+
+	s.Flags |= kASYNCLowLatency
+
+	if err := ioctl(f, syscall.TIOCSSERIAL, unsafe.Pointer(&s)); err != nil {
+		return &os.PathError{Op: "TIOCSSERIAL", Path: f.Name(), Err: err}
+	}
+
+	return nil
+}
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var errno syscall.Errno
+	if err := conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
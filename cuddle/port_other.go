@@ -0,0 +1,37 @@
+//go:build !linux
+// +build !linux
+
+package cuddle
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+func openSerial(name string, c *PortConfig) (Port, error) {
+	if err := execWithLogging("stty", "/bin/stty", "-f", name, strconv.Itoa(c.Baud), "raw"); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(name, os.O_RDWR, 0600)
+}
+
+// Open a new pseudo-terminal.
+func OpenPty() (*Pty, error) {
+	return nil, errors.New("pseudo-terminals are not supported on " + runtime.GOOS)
+}
+
+func execWithLogging(name string, args ...string) error {
+	l := log.New(logOut, "["+name+"] ", 0)
+	l.Println(strings.Join(args, " "))
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = logErr
+	cmd.Stdout = logOut
+
+	return cmd.Run()
+}
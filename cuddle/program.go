@@ -0,0 +1,234 @@
+package cuddle
+
+import (
+	"encoding"
+	"log"
+	"sync"
+	"time"
+
+	"../msgtype"
+)
+
+// Setpoints left free in each chunk of a long program for the remainder of
+// the chunk before it.
+const chunkReserve = 16
+
+// Time before a chunk of a long program runs out at which the next chunk is
+// sent.
+var ChunkLead = 250 * time.Millisecond
+
+var programErr = log.New(logErr, "[program] ", 0)
+
+// Uploader sends a setpoint program too long for one message as consecutive
+// chunks. Each chunk is sent shortly before the one before it runs out, and
+// starts with what is left of it, so that the actuator follows the program
+// without a break.
+type Uploader struct {
+	program *msgtype.Setpoint
+	send    func(encoding.BinaryMarshaler) error
+	now     func() time.Time
+
+	next    int               // index of the next setpoint, counting repeats
+	held    bool              // a setpoint held forever has been sent
+	chunk   *msgtype.Setpoint // the last chunk sent
+	sentAt  time.Time         // time the last chunk was sent
+	latency time.Duration     // time taken to send the last chunk
+}
+
+// Create an uploader sending chunks of a program with a function such as
+// Send. Looping programs are unrolled, forever if need be.
+func NewUploader(m *msgtype.Setpoint, send func(encoding.BinaryMarshaler) error) *Uploader {
+	return &Uploader{program: m, send: send, now: time.Now}
+}
+
+// Check whether every chunk has been sent.
+func (u *Uploader) Done() bool {
+	if u.held || len(u.program.Setpoints) == 0 {
+		return true
+	}
+	if u.program.Loop == msgtype.LOOP_INFINITE {
+		return false
+	}
+	return u.next >= len(u.program.Setpoints)*(int(u.program.Loop)+1)
+}
+
+// Get the time at which the next chunk should be sent: a little before the
+// last chunk runs out, once no more than chunkReserve of its setpoints are
+// left to play.
+func (u *Uploader) Due() time.Time {
+	if u.chunk == nil {
+		return u.now()
+	}
+
+	delay := time.Duration(u.chunk.Delay) * time.Millisecond
+	starts := make([]time.Duration, len(u.chunk.Setpoints)+1)
+	starts[0] = delay
+	for i, sp := range u.chunk.Setpoints {
+		starts[i+1] = starts[i] + time.Duration(sp.Duration)*time.Millisecond
+	}
+
+	due := starts[len(starts)-1] - ChunkLead - u.latency
+	if n := len(u.chunk.Setpoints) - chunkReserve; n > 0 && starts[n] > due {
+		due = starts[n]
+	}
+	if due < delay {
+		due = delay
+	}
+	return u.sentAt.Add(due)
+}
+
+// Send the next chunk.
+func (u *Uploader) Next() error {
+	chunk := &msgtype.Setpoint{Addr: u.program.Addr}
+	if u.chunk == nil {
+		chunk.Delay = u.program.Delay
+	} else {
+		// allow for the time taken to send the chunk
+		elapsed := u.now().Sub(u.sentAt) + u.latency -
+			time.Duration(u.chunk.Delay)*time.Millisecond
+		if elapsed < 0 {
+			chunk.Delay = uint16(-elapsed / time.Millisecond)
+			elapsed = 0
+		}
+		chunk.Setpoints = remainingSetpoints(u.chunk.Setpoints, elapsed)
+	}
+
+	n := len(u.program.Setpoints)
+	for len(chunk.Setpoints) < msgtype.MaxSetpoints && !u.Done() {
+		sp := u.program.Setpoints[u.next%n]
+		chunk.Setpoints = append(chunk.Setpoints, sp)
+		u.next++
+		u.held = sp.Duration == msgtype.LOOP_INFINITE
+	}
+
+	start := u.now()
+	if err := u.send(chunk); err != nil {
+		return err
+	}
+	u.sentAt = u.now()
+	u.latency = u.sentAt.Sub(start)
+	u.chunk = chunk
+	return nil
+}
+
+// Send every remaining chunk, each when it is due, until the program is
+// fully sent or stop is closed.
+func (u *Uploader) Run(stop <-chan struct{}) error {
+	for !u.Done() {
+		if u.chunk != nil {
+			select {
+			case <-time.After(u.Due().Sub(u.now())):
+			case <-stop:
+				return nil
+			}
+		}
+		if err := u.Next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get the setpoints left to play the given time after they started.
+func remainingSetpoints(values []msgtype.SetpointValue, t time.Duration) []msgtype.SetpointValue {
+	for i, sp := range values {
+		d := time.Duration(sp.Duration) * time.Millisecond
+		if sp.Duration == msgtype.LOOP_INFINITE || t < d {
+			rest := make([]msgtype.SetpointValue, 0, len(values)-i)
+			if sp.Duration == msgtype.LOOP_INFINITE {
+				rest = append(rest, sp)
+			} else if left := uint16((d - t) / time.Millisecond); left > 0 {
+				rest = append(rest, msgtype.SetpointValue{left, sp.Setpoint})
+			}
+			return append(rest, values[i+1:]...)
+		}
+		t -= d
+	}
+	return nil
+}
+
+// Programs being uploaded, by actuator.
+var uploads = struct {
+	sync.Mutex
+	running map[msgtype.RemoteAddress]*upload
+}{running: make(map[msgtype.RemoteAddress]*upload)}
+
+// A program being uploaded in the background.
+type upload struct {
+	stop    chan struct{}
+	current encoding.BinaryMarshaler // the chunk being sent
+}
+
+// Send a setpoint program of any length. Programs too long for one message
+// are split into chunks; the first is sent before returning and the rest in
+// the background, until the program ends or another motion or sleep message
+// is sent to the actuator. Limits are checked on the whole program, as the
+// remainder a chunk starts with may be shorter than any setpoint in it.
+func SendProgram(m *msgtype.Setpoint) error {
+	if len(m.Setpoints) <= msgtype.MaxSetpoints {
+		return Send(m)
+	}
+	if err := CheckLimits(m); err != nil {
+		return err
+	}
+
+	origin := originOf(m)
+	u := &upload{stop: make(chan struct{})}
+	uploads.Lock()
+	if old := uploads.running[m.Addr]; old != nil {
+		close(old.stop)
+	}
+	uploads.running[m.Addr] = u
+	uploads.Unlock()
+
+	up := NewUploader(m, func(chunk encoding.BinaryMarshaler) error {
+		uploads.Lock()
+		if uploads.running[m.Addr] != u {
+			uploads.Unlock()
+			return SupersededError
+		}
+		u.current = chunk
+		uploads.Unlock()
+		defer setOrigin(chunk, origin)()
+		return DefaultTransport.sendChecked(chunk, DefaultTimeout)
+	})
+	if err := up.Next(); err != nil {
+		u.finish(m.Addr)
+		return err
+	}
+
+	go func() {
+		if err := up.Run(u.stop); err != nil && err != SupersededError {
+			programErr.Printf("Failed to send program to %q: %s", rune(m.Addr), err.Error())
+		}
+		u.finish(m.Addr)
+	}()
+	return nil
+}
+
+// Stop the upload to an actuator when another motion or sleep message is
+// sent to it.
+func uploadSent(m encoding.BinaryMarshaler) {
+	if !isMotion(m) && !isSleep(m) {
+		return
+	}
+
+	uploads.Lock()
+	defer uploads.Unlock()
+
+	addr := msgtype.AddressOf(m)
+	if u := uploads.running[addr]; u != nil && u.current != m {
+		close(u.stop)
+		delete(uploads.running, addr)
+	}
+}
+
+// Forget a finished upload.
+func (u *upload) finish(addr msgtype.RemoteAddress) {
+	uploads.Lock()
+	defer uploads.Unlock()
+
+	if uploads.running[addr] == u {
+		delete(uploads.running, addr)
+	}
+}
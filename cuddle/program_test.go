@@ -0,0 +1,117 @@
+package cuddle
+
+import (
+	"encoding"
+	"testing"
+	"time"
+
+	"../msgtype"
+)
+
+func TestUploader(t *testing.T) {
+	program := &msgtype.Setpoint{msgtype.RibsAddress, 100, 1, nil}
+	for i := 0; i < 400; i++ {
+		program.Setpoints = append(program.Setpoints, msgtype.SetpointValue{10, uint16(i)})
+	}
+
+	// the board plays the last chunk it received
+	clock := time.Unix(0, 0)
+	var chunks []*msgtype.Setpoint
+	var received []time.Time
+	u := NewUploader(program, func(m encoding.BinaryMarshaler) error {
+		if _, err := m.MarshalBinary(); err != nil {
+			return err
+		}
+		// sending takes a while
+		clock = clock.Add(5 * time.Millisecond)
+		chunks = append(chunks, m.(*msgtype.Setpoint))
+		received = append(received, clock)
+		return nil
+	})
+	u.now = func() time.Time { return clock }
+
+	for !u.Done() {
+		clock = u.Due()
+		if err := u.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(chunks) < 4 {
+		t.Fatalf("Expected at least 4 chunks, got %d", len(chunks))
+	}
+
+	start := received[0]
+	for at := time.Duration(0); at < 8100*time.Millisecond; at += 5 * time.Millisecond {
+		k := len(received) - 1
+		for k > 0 && received[k].After(start.Add(at)) {
+			k--
+		}
+		got, gotOK := chunks[k].ValueAt(start.Add(at).Sub(received[k]))
+		expect, expectOK := program.ValueAt(at)
+		if got != expect || gotOK != expectOK {
+			t.Fatalf("At %v in chunk %d: expected %d %v, got %d %v", at, k, expect, expectOK, got, gotOK)
+		}
+	}
+}
+
+func TestUploaderLimits(t *testing.T) {
+	SetLimits(msgtype.RibsAddress, Limits{Max: 0xffff, MinDuration: 20})
+	defer SetLimits(msgtype.RibsAddress, NoLimits)
+
+	program := &msgtype.Setpoint{msgtype.RibsAddress, 0, 0, nil}
+	for i := 0; i < 400; i++ {
+		program.Setpoints = append(program.Setpoints, msgtype.SetpointValue{30, uint16(i)})
+	}
+	if err := CheckLimits(program); err != nil {
+		t.Fatal(err)
+	}
+
+	server, board := Pipe()
+	defer server.Close()
+	defer board.Close()
+	go func() {
+		r := msgtype.NewReader(board)
+		for {
+			if _, err := r.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	tr := NewTransport()
+	go tr.Run(server)
+
+	// chunks after the first start with a remainder shorter than the
+	// minimum duration, but the program as a whole is within limits
+	clock := time.Unix(0, 0)
+	short := false
+	u := NewUploader(program, func(m encoding.BinaryMarshaler) error {
+		clock = clock.Add(5 * time.Millisecond)
+		short = short || CheckLimits(m) != nil
+		return tr.sendChecked(m, time.Second)
+	})
+	u.now = func() time.Time { return clock }
+
+	for !u.Done() {
+		clock = u.Due()
+		if err := u.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !short {
+		t.Fatal("Expected a chunk outside the limits on its own")
+	}
+}
+
+func TestRemainingSetpoints(t *testing.T) {
+	values := []msgtype.SetpointValue{{100, 1}, {100, 2}, {msgtype.LOOP_INFINITE, 3}}
+
+	if rest := remainingSetpoints(values, 150*time.Millisecond); len(rest) != 2 || rest[0] != (msgtype.SetpointValue{50, 2}) {
+		t.Fatalf("Unexpected setpoints %v", rest)
+	}
+	if rest := remainingSetpoints(values, time.Hour); len(rest) != 1 || rest[0] != values[2] {
+		t.Fatalf("Unexpected setpoints %v", rest)
+	}
+	if rest := remainingSetpoints(values[:2], time.Hour); len(rest) != 0 {
+		t.Fatalf("Unexpected setpoints %v", rest)
+	}
+}
@@ -0,0 +1,23 @@
+package cuddle
+
+import (
+	"os"
+)
+
+// Pty is a pseudo-terminal standing in for a serial port. The Pty reads and
+// writes the master side, while the slave side is opened by name like any
+// other serial port.
+type Pty struct {
+	*os.File
+	slave string
+}
+
+// Get the name of the slave side.
+func (p *Pty) SlaveName() string {
+	return p.slave
+}
+
+// Open the slave side in raw mode.
+func (p *Pty) OpenSlave() (Port, error) {
+	return OpenPortConfig(p.slave, &PortConfig{Baud: DefaultPortConfig.Baud})
+}
@@ -0,0 +1,32 @@
+package cuddle
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Open a new pseudo-terminal.
+func OpenPty() (*Pty, error) {
+	f, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// equivalent to unlockpt()
+	var unlock int32
+	if err := ioctl(f, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		f.Close()
+		return nil, &os.PathError{Op: "TIOCSPTLCK", Path: f.Name(), Err: err}
+	}
+
+	// equivalent to ptsname()
+	var n uint32
+	if err := ioctl(f, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		f.Close()
+		return nil, &os.PathError{Op: "TIOCGPTN", Path: f.Name(), Err: err}
+	}
+
+	return &Pty{f, fmt.Sprintf("/dev/pts/%d", n)}, nil
+}
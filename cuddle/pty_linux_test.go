@@ -0,0 +1,43 @@
+package cuddle
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestPty(t *testing.T) {
+	pty, err := OpenPty()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer pty.Close()
+
+	slave, err := pty.OpenSlave()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slave.Close()
+
+	// bytes that a terminal in cooked mode would translate or act upon
+	data := []byte{'r', '?', '\r', '\n', 0x03, 0x04, 0x11, 0x13, 0x7f, 0xff}
+
+	if _, err := slave.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(data))
+	if _, err := io.ReadFull(pty, buf); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf, data) {
+		t.Fatalf("Expected %v, got %v", data, buf)
+	}
+
+	if _, err := pty.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(slave, buf); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf, data) {
+		t.Fatalf("Expected %v, got %v", data, buf)
+	}
+}
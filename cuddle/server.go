@@ -7,8 +7,11 @@ Cuddlebot actuators.
 package cuddle
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"io"
+	"log"
 	"net/http"
 
 	"github.com/codegangsta/negroni"
@@ -17,6 +20,12 @@ import (
 
 type customHandler func(w http.ResponseWriter, req *http.Request, body io.Reader) error
 
+// Header carrying the request ID.
+const RequestIDHeader = "X-Request-ID"
+
+// Longest request ID accepted from a client.
+const maxRequestIDLength = 64
+
 var Debug = false
 
 func New() http.Handler {
@@ -25,13 +34,30 @@ func New() http.Handler {
 	http.HandleFunc("/1/sleep.json", makeHandler(sleepHandler))
 	http.HandleFunc("/1/smooth.json", makeHandler(smoothHandler))
 	http.HandleFunc("/1/setpid.json", makeHandler(setpidHandler))
+	http.HandleFunc("/1/ping.json", makeHandler(pingHandler))
+	http.HandleFunc("/1/estop.json", makeHandler(estopHandler))
+	http.HandleFunc("/1/lease.json", makeHandler(leaseHandler))
+	http.HandleFunc("/1/actuators.json", makeHandler(actuatorsHandler))
+	http.HandleFunc("/1/status.json", makeHandler(statusHandler))
+	http.HandleFunc(actuatorsPath, makeHandler(actuatorsV2Handler))
+	http.HandleFunc(actuatorsPath+"/", makeHandler(actuatorsV2Handler))
+	http.HandleFunc(behavioursPath, makeHandler(behavioursHandler))
+	http.HandleFunc(behavioursPath+"/", makeHandler(behavioursHandler))
+	http.HandleFunc(timelinePath, makeHandler(timelineHandler))
+	http.HandleFunc(timelinePath+"/", makeHandler(timelineHandler))
+	http.HandleFunc(replayPath, makeHandler(replayHandler))
 	http.Handle("/1/data.json", negroni.New(
 		gzip.Gzip(gzip.DefaultCompression),
 		negroni.Wrap(makeHandler(dataHandler)),
 	))
 
 	// use negroni
-	n := negroni.New(negroni.NewRecovery(), negroni.NewLogger())
+	recovery := negroni.NewRecovery()
+	recovery.Logger = log.New(logErr, "[negroni] ", 0)
+	logger := negroni.NewLogger()
+	logger.ALogger = log.New(logOut, "[negroni] ", 0)
+	n := negroni.New(recovery, logger,
+		negroni.HandlerFunc(identify), negroni.HandlerFunc(authorize))
 	n.UseHandler(http.DefaultServeMux)
 
 	return http.Handler(n)
@@ -41,11 +67,41 @@ func makeHandler(fn customHandler) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		if err := fn(w, req, req.Body); err != nil {
-			if err := json.NewEncoder(w).Encode(err); err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				io.WriteString(w, `{"ok":false,"error":"InternalServerError"}`)
-			}
+		body := recordRequest(req, req.Body)
+		if err := fn(w, req, body); err != nil {
+			writeError(w, err)
 		}
 	}
 }
+
+// Write an error with its status code and the ID of the request. Errors
+// other than *Error are reported as internal server errors.
+func writeError(w http.ResponseWriter, err error) {
+	e, ok := err.(*Error)
+	if !ok {
+		e = InternalServerError.withDetail("%s", err.Error())
+	}
+
+	c := *e
+	c.RequestID = w.Header().Get(RequestIDHeader)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(c.Status())
+	if err := json.NewEncoder(w).Encode(&c); err != nil {
+		io.WriteString(w, `{"ok":false,"error":"InternalServerError","code":"internal"}`)
+	}
+}
+
+// Middleware giving each request an ID, taken from the request header if
+// the client sent one, and setting it in the request and response headers.
+func identify(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	id := req.Header.Get(RequestIDHeader)
+	if id == "" || len(id) > maxRequestIDLength {
+		b := make([]byte, 8)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+	req.Header.Set(RequestIDHeader, id)
+	w.Header().Set(RequestIDHeader, id)
+	next(w, req)
+}
@@ -0,0 +1,54 @@
+package cuddle
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteError(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		code   string
+		field  string
+	}{
+		{QueueFullError, 503, "queue_full", ""},
+		{SerialDownError, 503, "serial_down", ""},
+		{NotImplementedError, 501, "not_implemented", ""},
+		{missingField("addr"), 400, "missing_field", "addr"},
+		{errors.New("boom"), 500, "internal", ""},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/1/ping.json", nil)
+		req.Header.Set(RequestIDHeader, "abc123")
+		identify(w, req, func(w http.ResponseWriter, req *http.Request) {
+			writeError(w, c.err)
+		})
+
+		if w.Code != c.status {
+			t.Errorf("%v: expected status %d, got %d", c.err, c.status, w.Code)
+		}
+
+		var e Error
+		if err := json.NewDecoder(w.Body).Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		if e.OK || e.Code != c.code || e.Field != c.field || e.RequestID != "abc123" {
+			t.Errorf("%v: unexpected body %+v", c.err, e)
+		}
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/1/ping.json", nil)
+	identify(w, req, func(w http.ResponseWriter, req *http.Request) {})
+	if id := w.Header().Get(RequestIDHeader); len(id) != 16 {
+		t.Fatalf("expected generated request ID, got %q", id)
+	}
+}
@@ -0,0 +1,372 @@
+package cuddle
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"../msgtype"
+)
+
+// Kinds of session entries.
+const (
+	SessionRequest  = "request"  // HTTP request
+	SessionSent     = "sent"     // message sent to an actuator
+	SessionReceived = "received" // message received from an actuator
+)
+
+// Most bytes of a request body written to a session.
+const maxRecordedBody = 64 << 10
+
+// An entry in a session file, which holds one JSON entry per line.
+type SessionEntry struct {
+	Time      time.Time       `json:"time"`
+	Kind      string          `json:"kind"`
+	RequestID string          `json:"request_id,omitempty"` // request on whose behalf a message was sent
+	Request   *RequestRecord  `json:"request,omitempty"`    // request entries
+	Frame     string          `json:"frame,omitempty"`      // message as sent on the wire, in hex
+	Type      string          `json:"type,omitempty"`       // message type
+	Actuator  string          `json:"actuator,omitempty"`   // name of the actuator
+	Message   json.RawMessage `json:"message,omitempty"`    // decoded message
+}
+
+// An HTTP request in a session.
+type RequestRecord struct {
+	Method    string `json:"method"`
+	URL       string `json:"url"`
+	Remote    string `json:"remote"`
+	Body      string `json:"body,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"` // body cut short at maxRecordedBody
+}
+
+// Recorder writes session entries to a file.
+type Recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// Create a recorder writing to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// Write an entry.
+func (r *Recorder) Record(e *SessionEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(e)
+}
+
+// The session being recorded, and the requests on whose behalf messages
+// are being sent.
+var recording = struct {
+	sync.Mutex
+	f        *os.File
+	recorder *Recorder
+	origins  map[encoding.BinaryMarshaler]string
+}{origins: make(map[encoding.BinaryMarshaler]string)}
+
+var sessionErr = log.New(logErr, "[session] ", 0)
+
+// Record sessions to a file, appending to it, or stop recording if the name
+// is empty. Setting the same name again reopens the file, so that it may be
+// rotated.
+func SetRecordFile(name string) error {
+	f, err := openLogFile(name)
+	if err != nil {
+		return err
+	}
+	useRecordFile(f)
+	return nil
+}
+
+// Record sessions to an open file, or stop recording if nil, closing the
+// file used before.
+func useRecordFile(f *os.File) {
+	recording.Lock()
+	defer recording.Unlock()
+
+	if recording.f != nil {
+		recording.f.Close()
+	}
+	recording.f = f
+	recording.recorder = nil
+	if f != nil {
+		recording.recorder = NewRecorder(f)
+	}
+}
+
+// Get the recorder, or nil if not recording.
+func sessionRecorder() *Recorder {
+	recording.Lock()
+	defer recording.Unlock()
+	return recording.recorder
+}
+
+func record(r *Recorder, e *SessionEntry) {
+	if err := r.Record(e); err != nil {
+		sessionErr.Printf("Failed to record %s entry %s", e.Kind, err.Error())
+	}
+}
+
+// Record a request that may command the actuators, returning its body to be
+// read in its place.
+func recordRequest(req *http.Request, body io.Reader) io.Reader {
+	r := sessionRecorder()
+	if r == nil || req.Method == "GET" {
+		return body
+	}
+
+	buf, err := ioutil.ReadAll(body)
+	if err != nil {
+		sessionErr.Printf("Failed to read request body %s", err.Error())
+	}
+	rec := &RequestRecord{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Remote: req.RemoteAddr,
+		Body:   string(buf),
+	}
+	if len(buf) > maxRecordedBody {
+		rec.Body = string(buf[:maxRecordedBody])
+		rec.Truncated = true
+	}
+	record(r, &SessionEntry{
+		Time:      time.Now(),
+		Kind:      SessionRequest,
+		RequestID: req.Header.Get(RequestIDHeader),
+		Request:   rec,
+	})
+	return bytes.NewReader(buf)
+}
+
+// Note the request on whose behalf a message is sent, until the returned
+// function is called.
+func setOrigin(m encoding.BinaryMarshaler, id string) func() {
+	recording.Lock()
+	defer recording.Unlock()
+
+	if recording.recorder == nil || id == "" {
+		return func() {}
+	}
+	recording.origins[m] = id
+	return func() {
+		recording.Lock()
+		delete(recording.origins, m)
+		recording.Unlock()
+	}
+}
+
+// Get the request on whose behalf a message is sent.
+func originOf(m encoding.BinaryMarshaler) string {
+	recording.Lock()
+	defer recording.Unlock()
+	return recording.origins[m]
+}
+
+// Record a message written to the serial port.
+func recordSent(m encoding.BinaryMarshaler, frame []byte) {
+	if r := sessionRecorder(); r != nil {
+		e := newSessionEntry(SessionSent, m, frame)
+		e.RequestID = originOf(m)
+		record(r, e)
+	}
+}
+
+// Record a message read from the serial port.
+func recordReceived(m msgtype.Message, frame []byte) {
+	if r := sessionRecorder(); r != nil {
+		record(r, newSessionEntry(SessionReceived, m, frame))
+	}
+}
+
+func newSessionEntry(kind string, m encoding.BinaryMarshaler, frame []byte) *SessionEntry {
+	e := &SessionEntry{
+		Time:  time.Now(),
+		Kind:  kind,
+		Frame: hex.EncodeToString(frame),
+		Type:  msgtype.NameOf(m),
+	}
+	if info, ok := msgtype.LookupAddress(msgtype.AddressOf(m)); ok {
+		e.Actuator = info.Name
+	}
+	e.Message, _ = json.Marshal(m)
+	return e
+}
+
+// Read the entries of a session.
+func ReadSession(r io.Reader) ([]*SessionEntry, error) {
+	var entries []*SessionEntry
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 4*maxRecordedBody)
+	for line := 1; s.Scan(); line++ {
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
+		e := &SessionEntry{}
+		if err := json.Unmarshal(s.Bytes(), e); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		entries = append(entries, e)
+	}
+	return entries, s.Err()
+}
+
+// Replayer re-sends the commands recorded in a session with their original
+// timing, sped up or slowed down. Requests for replies, such as position
+// polls, are left out.
+type Replayer struct {
+	commands []replayCommand
+	send     func(encoding.BinaryMarshaler) error
+
+	mu    sync.Mutex
+	sent  int
+	addrs []msgtype.RemoteAddress // actuators sent commands so far
+}
+
+// A command to replay.
+type replayCommand struct {
+	at time.Duration // time from the start of the replay
+	m  msgtype.Message
+}
+
+// Create a replayer for the messages sent in a session, played at a speed
+// relative to the original, sending each command with a function such as
+// Send. The timing of the motion in each command is scaled to match.
+func NewReplayer(entries []*SessionEntry, speed float64, send func(encoding.BinaryMarshaler) error) (*Replayer, error) {
+	if speed <= 0 {
+		return nil, fmt.Errorf("invalid speed %g", speed)
+	}
+
+	p := &Replayer{send: send}
+	var start time.Time
+	for i, e := range entries {
+		if e.Kind != SessionSent {
+			continue
+		}
+		frame, err := hex.DecodeString(e.Frame)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %s", i+1, err.Error())
+		}
+		m, err := msgtype.Unmarshal(frame)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %s", i+1, err.Error())
+		}
+		switch m.(type) {
+		case *msgtype.Ping, *msgtype.Value:
+			continue
+		}
+
+		if len(p.commands) == 0 {
+			start = e.Time
+		}
+		at := time.Duration(float64(e.Time.Sub(start)) / speed)
+		p.commands = append(p.commands, replayCommand{at, scaleTiming(m, speed)})
+	}
+	return p, nil
+}
+
+// Scale the delays and durations of a setpoint or smooth message for a
+// replay at the given speed.
+func scaleTiming(m msgtype.Message, speed float64) msgtype.Message {
+	switch m := m.(type) {
+	case *msgtype.Setpoint:
+		m.Delay = scaleMillis(m.Delay, speed)
+		for i := range m.Setpoints {
+			m.Setpoints[i].Duration = scaleMillis(m.Setpoints[i].Duration, speed)
+		}
+	case *msgtype.Smooth:
+		m.Time = scaleMillis(m.Time, speed)
+		for i := range m.Setpoint {
+			m.Setpoint[i].Duration = scaleMillis(m.Setpoint[i].Duration, speed)
+		}
+	}
+	return m
+}
+
+// Scale a time in ms by 1 / speed. Durations held forever are left alone,
+// and others stay finite and no shorter than 1ms.
+func scaleMillis(ms uint16, speed float64) uint16 {
+	if ms == msgtype.LOOP_INFINITE || ms == 0 {
+		return ms
+	}
+	scaled := math.Floor(float64(ms)/speed + 0.5)
+	switch {
+	case scaled < 1:
+		return 1
+	case scaled >= float64(msgtype.LOOP_INFINITE):
+		return msgtype.LOOP_INFINITE - 1
+	}
+	return uint16(scaled)
+}
+
+// Get the number of commands to replay.
+func (p *Replayer) Len() int {
+	return len(p.commands)
+}
+
+// Get the running time of the replay.
+func (p *Replayer) Duration() time.Duration {
+	if len(p.commands) == 0 {
+		return 0
+	}
+	return p.commands[len(p.commands)-1].at
+}
+
+// Get the number of commands sent so far.
+func (p *Replayer) Sent() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sent
+}
+
+// Get the actuators sent commands so far.
+func (p *Replayer) Actuators() []msgtype.RemoteAddress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]msgtype.RemoteAddress(nil), p.addrs...)
+}
+
+// Check whether any command in the replay is for an actuator.
+func (p *Replayer) drives(addr msgtype.RemoteAddress) bool {
+	for _, c := range p.commands {
+		if msgtype.AddressOf(c.m) == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// Send every command, each when it is due, until the session ends or stop
+// is closed.
+func (p *Replayer) Run(stop <-chan struct{}) error {
+	start := time.Now()
+	for _, c := range p.commands {
+		select {
+		case <-time.After(start.Add(c.at).Sub(time.Now())):
+		case <-stop:
+			return nil
+		}
+		if err := p.send(c.m); err != nil {
+			return err
+		}
+		p.mu.Lock()
+		p.sent++
+		if addr := msgtype.AddressOf(c.m); !containsAddress(p.addrs, addr) {
+			p.addrs = append(p.addrs, addr)
+		}
+		p.mu.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package cuddle
+
+import (
+	"bytes"
+	"encoding"
+	"testing"
+	"time"
+
+	"../msgtype"
+)
+
+func TestSessionReplay(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewRecorder(&buf)
+
+	start := time.Now()
+	messages := []encoding.BinaryMarshaler{
+		&msgtype.Setpoint{msgtype.RibsAddress, 0, 0, []msgtype.SetpointValue{{100, 1}}},
+		&msgtype.Value{msgtype.RibsAddress},
+		&msgtype.Reading{msgtype.RibsAddress, 1},
+		&msgtype.Sleep{msgtype.RibsAddress},
+	}
+	for i, m := range messages {
+		frame, _ := m.MarshalBinary()
+		kind := SessionSent
+		if _, ok := m.(*msgtype.Reading); ok {
+			kind = SessionReceived
+		}
+		e := newSessionEntry(kind, m, frame)
+		e.Time = start.Add(time.Duration(i) * 20 * time.Millisecond)
+		if err := r.Record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := ReadSession(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 || entries[0].Type != "setpoint" || entries[0].Actuator != "ribs" || entries[2].Kind != SessionReceived {
+		t.Fatalf("Unexpected entries %+v", entries)
+	}
+
+	// polls and replies are left out, and timing follows the speed
+	var sent []encoding.BinaryMarshaler
+	var at []time.Time
+	p, err := NewReplayer(entries, 2, func(m encoding.BinaryMarshaler) error {
+		sent = append(sent, m)
+		at = append(at, time.Now())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Len() != 2 || p.Duration() != 30*time.Millisecond {
+		t.Fatalf("Expected 2 commands over 30ms, got %d over %v", p.Len(), p.Duration())
+	}
+	if err := p.Run(nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 2 || p.Sent() != 2 {
+		t.Fatalf("Expected 2 commands sent, got %d", len(sent))
+	}
+	if sp := sent[0].(*msgtype.Setpoint); sp.Setpoints[0].Duration != 50 {
+		t.Fatalf("Expected duration scaled to 50ms, got %+v", sp)
+	}
+	if _, ok := sent[1].(*msgtype.Sleep); !ok {
+		t.Fatalf("Expected sleep, got %+v", sent[1])
+	}
+	if addrs := p.Actuators(); len(addrs) != 1 || addrs[0] != msgtype.RibsAddress {
+		t.Fatalf("Unexpected actuators %v", addrs)
+	}
+	if d := at[1].Sub(at[0]); d < 30*time.Millisecond {
+		t.Fatalf("Commands sent %v apart", d)
+	}
+}
+
+func TestScaleTiming(t *testing.T) {
+	m := scaleTiming(&msgtype.Smooth{msgtype.RibsAddress, 10, []msgtype.SetpointValue{
+		{1000, 1}, {msgtype.LOOP_INFINITE, 2},
+	}}, 0.5).(*msgtype.Smooth)
+	if m.Time != 20 || m.Setpoint[0].Duration != 2000 || m.Setpoint[1].Duration != msgtype.LOOP_INFINITE {
+		t.Fatalf("Unexpected timing %+v", m)
+	}
+
+	for _, c := range []struct {
+		ms     uint16
+		speed  float64
+		expect uint16
+	}{
+		{100, 3, 33},
+		{1, 4, 1},
+		{0, 2, 0},
+		{40000, 0.5, msgtype.LOOP_INFINITE - 1},
+		{msgtype.LOOP_INFINITE, 2, msgtype.LOOP_INFINITE},
+	} {
+		if got := scaleMillis(c.ms, c.speed); got != c.expect {
+			t.Fatalf("Scaling %dms by %g: expected %d, got %d", c.ms, c.speed, c.expect, got)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package cuddle
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"../msgtype"
+)
+
+// Connection states.
+const (
+	Connecting   = "connecting"
+	Connected    = "connected"
+	Disconnected = "disconnected"
+)
+
+// Time to wait before reopening the port, doubling after each failure
+// until a connection stays up for stableConnection.
+const (
+	minReconnectDelay = 100 * time.Millisecond
+	maxReconnectDelay = 10 * time.Second
+	stableConnection  = 5 * time.Second
+)
+
+// Serial connection status.
+type ConnectionStatus struct {
+	State      string              `json:"state"`
+	Port       string              `json:"port,omitempty"`
+	Since      time.Time           `json:"since"`      // time of last state change
+	Reconnects int                 `json:"reconnects"` // successful reopens after a failure
+	LastError  string              `json:"last_error,omitempty"`
+	Reader     msgtype.ReaderStats `json:"reader"`
+}
+
+type connectionMonitor struct {
+	mu     sync.Mutex
+	status ConnectionStatus
+}
+
+var connection = &connectionMonitor{
+	status: ConnectionStatus{State: Disconnected, Since: time.Now()},
+}
+
+var supervisorOut = log.New(logOut, "[supervisor] ", 0)
+var supervisorErr = log.New(logErr, "[supervisor] ", 0)
+
+// Keep the default transport connected to a port, reopening it with backoff
+// after errors. If restore is set, the last PID coefficients and running
+// setpoints are sent again after reconnecting.
+func Supervise(open func() (Port, error), restore bool) {
+	supervise(open, restore, nil)
+}
+
+// Keep the default transport connected until stop is closed.
+func supervise(open func() (Port, error), restore bool, stop <-chan struct{}) {
+	delay := minReconnectDelay
+	opened := false
+
+	// wait before reopening, returning false if stopped
+	wait := func() bool {
+		select {
+		case <-stop:
+			return false
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+		return true
+	}
+
+	for {
+		connection.set(Connecting, "", nil)
+
+		port, err := open()
+		if err != nil {
+			supervisorErr.Printf("Failed to open port %s, retrying in %s",
+				err.Error(), delay)
+			connection.set(Disconnected, "", err)
+			if !wait() {
+				return
+			}
+			continue
+		}
+
+		if opened {
+			connection.reconnected()
+		}
+		connection.set(Connected, port.Name(), nil)
+		supervisorOut.Println("Connected to", port.Name())
+
+		reopened := opened
+		opened = true
+		up := time.Now()
+		err = DefaultTransport.run(port, func() {
+			sendDefaultPID()
+			if reopened && restore {
+				restoreActuators()
+			}
+		})
+		port.Close()
+
+		supervisorErr.Printf("Lost connection to %s %s", port.Name(), err.Error())
+		connection.set(Disconnected, port.Name(), err)
+
+		// keep backing off from a port that fails soon after opening
+		if time.Since(up) >= stableConnection {
+			delay = minReconnectDelay
+		}
+		if !wait() {
+			return
+		}
+	}
+}
+
+// Get the serial connection status.
+func Connection() ConnectionStatus {
+	connection.mu.Lock()
+	status := connection.status
+	connection.mu.Unlock()
+
+	status.Reader = DefaultTransport.ReaderStats()
+	return status
+}
+
+// Send the messages needed to restore the actuators after reconnecting.
+func restoreActuators() {
+	for _, m := range telemetry.restoreMessages() {
+		if err := Send(m); err != nil {
+			supervisorErr.Printf("Failed to restore %+v %s", m, err.Error())
+			return
+		}
+	}
+}
+
+func (c *connectionMonitor) set(state, port string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.status.State != state {
+		c.status.State = state
+		c.status.Since = time.Now()
+	}
+	if port != "" {
+		c.status.Port = port
+	}
+	if err != nil {
+		c.status.LastError = err.Error()
+	}
+}
+
+func (c *connectionMonitor) reconnected() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.status.Reconnects++
+}
@@ -0,0 +1,108 @@
+package cuddle
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"../msgtype"
+)
+
+func TestSupervise(t *testing.T) {
+	saved := DefaultTransport
+	DefaultTransport = NewTransport()
+	defer func() { DefaultTransport = saved }()
+
+	// a running setpoint to restore after reconnecting
+	sp := &msgtype.Setpoint{msgtype.HeadYAddress, 0, msgtype.LOOP_INFINITE, []msgtype.SetpointValue{{100, 0x1000}}}
+	telemetry.sent(sp)
+	defer func() {
+		telemetry.mu.Lock()
+		delete(telemetry.states, msgtype.HeadYAddress)
+		telemetry.mu.Unlock()
+	}()
+
+	// the first open fails, and each port after it is handed to the test
+	type opening struct {
+		at    time.Time
+		robot Port
+	}
+	openings := make(chan opening, 4)
+	n := 0
+	open := func() (Port, error) {
+		if n++; n == 1 {
+			openings <- opening{time.Now(), nil}
+			return nil, errors.New("no such port")
+		}
+		robot, port := Pipe()
+		openings <- opening{time.Now(), robot}
+		return port, nil
+	}
+
+	// wait for the robot to receive the setpoint, ignoring other messages
+	restored := func(robot Port, timeout time.Duration) bool {
+		c := make(chan bool, 1)
+		go func() {
+			r := msgtype.NewReader(robot)
+			for {
+				frame, err := r.ReadFrame()
+				if err != nil {
+					c <- false
+					return
+				}
+				if m, err := frame.Message(); err == nil {
+					if m, ok := m.(*msgtype.Setpoint); ok && m.Addr == sp.Addr {
+						c <- true
+						return
+					}
+				}
+			}
+		}()
+		select {
+		case ok := <-c:
+			return ok
+		case <-time.After(timeout):
+			return false
+		}
+	}
+
+	reconnects := Connection().Reconnects
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		supervise(open, true, stop)
+		close(done)
+	}()
+
+	failed := <-openings
+	first := <-openings
+	if d := first.at.Sub(failed.at); d < minReconnectDelay {
+		t.Fatalf("expected to wait %s before reopening, waited %s", minReconnectDelay, d)
+	}
+
+	// nothing is restored on the first connection
+	if restored(first.robot, 50*time.Millisecond) {
+		t.Fatal("unexpected setpoint on the first connection")
+	}
+	if s := Connection(); s.State != Connected || s.Reconnects != reconnects {
+		t.Fatalf("unexpected status %+v", s)
+	}
+
+	// the port drops soon after opening, so the delay keeps doubling
+	first.robot.Close()
+	second := <-openings
+	if d := second.at.Sub(first.at); d < 2*minReconnectDelay {
+		t.Fatalf("expected to wait %s before reopening, waited %s", 2*minReconnectDelay, d)
+	}
+
+	if !restored(second.robot, time.Second) {
+		t.Fatal("expected setpoint to be restored")
+	}
+	if s := Connection(); s.Reconnects != reconnects+1 {
+		t.Fatalf("expected %d reconnects, got %d", reconnects+1, s.Reconnects)
+	}
+
+	close(stop)
+	second.robot.Close()
+	<-done
+}
@@ -0,0 +1,207 @@
+package cuddle
+
+import (
+	"encoding"
+	"log"
+	"sync"
+	"time"
+
+	"../msgtype"
+)
+
+// Telemetry for an actuator.
+type actuatorState struct {
+	pid      *msgtype.SetPID   // last PID coefficients sent
+	position *uint16           // last position reading
+	setpoint *msgtype.Setpoint // last setpoints sent
+	smooth   *uint16           // last smooth motion target
+	sentAt   time.Time         // time setpoints were sent
+	sleeping bool              // sleep message sent
+	lastSeen time.Time         // time of last response
+}
+
+type telemetryStore struct {
+	mu     sync.Mutex
+	states map[msgtype.RemoteAddress]*actuatorState
+}
+
+var telemetry = &telemetryStore{
+	states: make(map[msgtype.RemoteAddress]*actuatorState),
+}
+
+var telemetryErr = log.New(logErr, "[telemetry] ", 0)
+
+// Request the positions of actuators able to report them at the given
+// interval.
+func PollPositions(interval time.Duration) {
+	for _ = range time.Tick(interval) {
+		for _, a := range msgtype.Actuators() {
+			if !a.Can(msgtype.CanValue) {
+				continue
+			}
+			if err := DefaultTransport.Post(&msgtype.Value{a.Addr}); err != nil && Debug {
+				telemetryErr.Printf("Failed to poll %s %s", a.Name, err.Error())
+			}
+		}
+	}
+}
+
+// Get the state for an address, creating it if needed.
+func (t *telemetryStore) state(addr msgtype.RemoteAddress) *actuatorState {
+	s, ok := t.states[addr]
+	if !ok {
+		s = &actuatorState{}
+		t.states[addr] = s
+	}
+	return s
+}
+
+// Get a copy of the state for an address.
+func (t *telemetryStore) get(addr msgtype.RemoteAddress) actuatorState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if s, ok := t.states[addr]; ok {
+		return *s
+	}
+	return actuatorState{}
+}
+
+// Record a message sent to the actuators.
+func (t *telemetryStore) sent(message encoding.BinaryMarshaler) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch m := message.(type) {
+	case *msgtype.SetPID:
+		t.state(m.Addr).pid = m
+	case *msgtype.Setpoint:
+		s := t.state(m.Addr)
+		s.setpoint = m
+		s.smooth = nil
+		s.sentAt = time.Now()
+		s.sleeping = false
+	case *msgtype.Smooth:
+		if len(m.Setpoint) == 0 {
+			return
+		}
+		target := m.Setpoint[len(m.Setpoint)-1].Setpoint
+		s := t.state(m.Addr)
+		s.setpoint = nil
+		s.smooth = &target
+		s.sleeping = false
+	case *msgtype.Sleep:
+		s := t.state(m.Addr)
+		s.setpoint = nil
+		s.smooth = nil
+		s.sleeping = true
+	}
+}
+
+// Get the messages needed to restore the actuators after they lose state,
+// such as when the serial connection drops: the last PID coefficients and
+// any setpoints that have not finished. Restored setpoints start again from
+// the beginning.
+func (t *telemetryStore) restoreMessages() []encoding.BinaryMarshaler {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	var messages []encoding.BinaryMarshaler
+
+	for _, addr := range msgtype.Addresses() {
+		s, ok := t.states[addr]
+		if !ok {
+			continue
+		}
+		if s.pid != nil {
+			messages = append(messages, s.pid)
+		}
+		if s.setpoint != nil && !s.sleeping {
+			if d, ok := s.setpoint.Duration(); !ok || now.Sub(s.sentAt) < d {
+				messages = append(messages, s.setpoint)
+			}
+		}
+	}
+
+	return messages
+}
+
+// Get the last position reading for an actuator.
+func (t *telemetryStore) position(addr msgtype.RemoteAddress) (uint16, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if s, ok := t.states[addr]; ok && s.position != nil {
+		return *s.position, true
+	}
+	return 0, false
+}
+
+// Record a message received from the actuators.
+func (t *telemetryStore) received(message msgtype.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch m := message.(type) {
+	case *msgtype.Reading:
+		s := t.state(m.Addr)
+		position := m.Position
+		s.position = &position
+		s.lastSeen = time.Now()
+	case *msgtype.Pong:
+		t.state(m.Addr).lastSeen = time.Now()
+	}
+}
+
+// Get telemetry for the given addresses, or all addresses if none are
+// given, seen within the given time window. A zero time leaves the window
+// open on that side. Positions are given in the units given, or the default
+// units of each actuator.
+func (t *telemetryStore) data(addrs []msgtype.RemoteAddress, since, until time.Time, units string) []*dataMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(addrs) == 0 {
+		addrs = msgtype.Addresses()
+	}
+
+	now := time.Now()
+	data := make([]*dataMessage, 0, len(addrs))
+
+	for _, addr := range addrs {
+		s, ok := t.states[addr]
+		if !ok {
+			continue
+		}
+		if !since.IsZero() && s.lastSeen.Before(since) {
+			continue
+		}
+		if !until.IsZero() && (s.lastSeen.IsZero() || s.lastSeen.After(until)) {
+			continue
+		}
+
+		setpoint := s.smooth
+		if s.setpoint != nil {
+			if v, ok := s.setpoint.ValueAt(now.Sub(s.sentAt)); ok {
+				setpoint = &v
+			}
+		}
+
+		info, _ := msgtype.LookupAddress(addr)
+		d := &dataMessage{
+			Addr:     addr,
+			Position: decodePosition(addr, s.position, units),
+			Setpoint: decodePosition(addr, setpoint, units),
+			Units:    info.UnitsOf(units),
+			Sleeping: s.sleeping,
+		}
+		if !s.lastSeen.IsZero() {
+			lastSeen := s.lastSeen
+			d.LastSeen = &lastSeen
+		}
+		data = append(data, d)
+	}
+
+	return data
+}
@@ -0,0 +1,63 @@
+package cuddle
+
+import (
+	"testing"
+	"time"
+
+	"../msgtype"
+)
+
+func TestTelemetryData(t *testing.T) {
+	store := &telemetryStore{states: make(map[msgtype.RemoteAddress]*actuatorState)}
+	if data := store.data(nil, time.Time{}, time.Time{}, ""); data == nil || len(data) != 0 {
+		t.Fatalf("expected no data, got %v", data)
+	}
+
+	// the ribs were seen just now, the purr motor an hour ago and the spine
+	// was put to sleep without ever replying
+	store.received(&msgtype.Reading{msgtype.RibsAddress, 0x4000})
+	store.received(&msgtype.Reading{msgtype.PurrAddress, 0x100})
+	store.states[msgtype.PurrAddress].lastSeen = time.Now().Add(-time.Hour)
+	store.sent(&msgtype.Sleep{msgtype.SpineAddress})
+
+	addrsOf := func(data []*dataMessage) []msgtype.RemoteAddress {
+		var addrs []msgtype.RemoteAddress
+		for _, d := range data {
+			addrs = append(addrs, d.Addr)
+		}
+		return addrs
+	}
+
+	if got := addrsOf(store.data(nil, time.Time{}, time.Time{}, "")); len(got) != 3 {
+		t.Fatalf("expected data for 3 actuators, got %v", got)
+	}
+	if got := addrsOf(store.data([]msgtype.RemoteAddress{msgtype.PurrAddress}, time.Time{}, time.Time{}, "")); len(got) != 1 || got[0] != msgtype.PurrAddress {
+		t.Fatalf("expected data for the purr motor, got %v", got)
+	}
+
+	minuteAgo := time.Now().Add(-time.Minute)
+	if got := addrsOf(store.data(nil, minuteAgo, time.Time{}, "")); len(got) != 1 || got[0] != msgtype.RibsAddress {
+		t.Fatalf("expected data for the ribs since a minute ago, got %v", got)
+	}
+	if got := addrsOf(store.data(nil, time.Time{}, minuteAgo, "")); len(got) != 1 || got[0] != msgtype.PurrAddress {
+		t.Fatalf("expected data for the purr motor until a minute ago, got %v", got)
+	}
+
+	ribs := []msgtype.RemoteAddress{msgtype.RibsAddress}
+	if d := store.data(ribs, time.Time{}, time.Time{}, msgtype.UnitsRaw)[0]; *d.Position != 0x4000 || d.Units != msgtype.UnitsRaw {
+		t.Fatalf("expected raw position 0x4000, got %v %s", *d.Position, d.Units)
+	}
+	if d := store.data(ribs, time.Time{}, time.Time{}, msgtype.UnitsDegrees)[0]; *d.Position != 90 || d.Units != msgtype.UnitsDegrees {
+		t.Fatalf("expected position 90 deg, got %v %s", *d.Position, d.Units)
+	}
+
+	// setpoints are not reported while sleeping
+	store.sent(&msgtype.Setpoint{msgtype.RibsAddress, 0, 0, []msgtype.SetpointValue{{0, 0x2000}}})
+	if d := store.data(ribs, time.Time{}, time.Time{}, msgtype.UnitsRaw)[0]; d.Setpoint == nil || *d.Setpoint != 0x2000 || d.Sleeping {
+		t.Fatalf("expected setpoint 0x2000, got %+v", d)
+	}
+	store.sent(&msgtype.Sleep{msgtype.RibsAddress})
+	if d := store.data(ribs, time.Time{}, time.Time{}, msgtype.UnitsRaw)[0]; d.Setpoint != nil || !d.Sleeping {
+		t.Fatalf("expected no setpoint while sleeping, got %+v", d)
+	}
+}
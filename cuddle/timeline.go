@@ -0,0 +1,244 @@
+package cuddle
+
+import (
+	"encoding"
+	"fmt"
+	"sync"
+	"time"
+
+	"../msgtype"
+	"../trajectory"
+)
+
+// Latest start of a track in ms, leaving room in the setpoint delay to
+// synchronise the tracks.
+const maxTrackStart = 60000
+
+// Time allowed for each message of a timeline to pass through the send
+// queue, on top of its transmission time.
+var SyncMargin = 10 * time.Millisecond
+
+// Timeline states.
+const (
+	TimelineStopped = "stopped"
+	TimelinePlaying = "playing"
+	TimelinePaused  = "paused"
+)
+
+// A timeline of coordinated motion for several actuators, as given in
+// requests.
+type timelineResource struct {
+	Units      string           `json:"units,omitempty"`
+	Resolution uint16           `json:"resolution"` // time between trajectory samples in ms, or 0 for the default
+	Tracks     []*trackResource `json:"tracks"`
+}
+
+// Motion of one actuator in a timeline: a list of setpoints or a
+// trajectory, starting some time into the timeline.
+type trackResource struct {
+	Actuator   string             `json:"actuator"`
+	Start      uint16             `json:"start"` // ms from the start of the timeline
+	Units      string             `json:"units,omitempty"`
+	Setpoints  []setpointResource `json:"setpoints,omitempty"`
+	Trajectory *trajectory.Spec   `json:"trajectory,omitempty"`
+}
+
+// A compiled timeline.
+type timeline struct {
+	tracks   []*track
+	duration time.Duration
+}
+
+// A compiled track.
+type track struct {
+	info   msgtype.ActuatorInfo
+	start  time.Duration
+	values []msgtype.SetpointValue
+}
+
+// The loaded timeline and where it is.
+var timelines = struct {
+	sync.Mutex // held while sending
+	current    *timeline
+	state      string
+	position   time.Duration // position when paused or stopped
+	startedAt  time.Time     // time the start of the timeline was played, if playing
+}{state: TimelineStopped}
+
+// Compile a timeline, converting its tracks to setpoints.
+func (r *timelineResource) compile(v *validator, units string) *timeline {
+	if r.Units != "" {
+		units = r.Units
+	}
+	if !v.require("tracks", len(r.Tracks) > 0) {
+		return nil
+	}
+
+	tl := &timeline{}
+	seen := make(map[msgtype.RemoteAddress]bool)
+	for i, tr := range r.Tracks {
+		field := fmt.Sprintf("tracks[%d]", i)
+		if !v.require(join(field, "actuator"), tr.Actuator != "") {
+			continue
+		}
+		info, ok := msgtype.LookupName(tr.Actuator)
+		if !ok {
+			v.add(NotFoundError.withField(join(field, "actuator"), "actuator %q", tr.Actuator))
+			continue
+		} else if seen[info.Addr] {
+			v.add(InvalidMessageError.withField(join(field, "actuator"), "%s has more than one track", tr.Actuator))
+			continue
+		}
+		seen[info.Addr] = true
+
+		if tr.Start > maxTrackStart {
+			v.add(InvalidMessageError.withField(join(field, "start"), "at most %d ms", maxTrackStart))
+		}
+		if values := tr.values(v, field, info, units, r.Resolution); values != nil {
+			t := &track{info, time.Duration(tr.Start) * time.Millisecond, values}
+			if d := t.start + t.duration(); d > tl.duration {
+				tl.duration = d
+			}
+			tl.tracks = append(tl.tracks, t)
+		}
+	}
+	return tl
+}
+
+// Get the setpoints of a track.
+func (tr *trackResource) values(v *validator, field string, info msgtype.ActuatorInfo, units string, resolution uint16) []msgtype.SetpointValue {
+	if tr.Units != "" {
+		units = tr.Units
+	}
+	if (tr.Setpoints == nil) == (tr.Trajectory == nil) {
+		v.add(InvalidMessageError.withField(field, "expected either setpoints or trajectory"))
+		return nil
+	}
+	if tr.Setpoints != nil {
+		return setpointValues(v, field, info.Addr, tr.Setpoints, units)
+	}
+
+	if tr.Trajectory.Forever() {
+		v.add(InvalidMessageError.withField(join(field, "trajectory.cycles"), "tracks cannot repeat forever"))
+		return nil
+	}
+	n := len(*v)
+	r := &trajectoryResource{Resolution: resolution, Trajectory: tr.Trajectory}
+	_, values := r.message(v, field, info.Addr, units)
+	if len(*v) > n {
+		return nil
+	}
+	return values
+}
+
+// Get the running time of a track.
+func (t *track) duration() time.Duration {
+	var d time.Duration
+	for _, sp := range t.values {
+		d += time.Duration(sp.Duration) * time.Millisecond
+	}
+	return d
+}
+
+// Get the message playing the track from a position in the timeline. Tracks
+// that have finished hold their last setpoint.
+func (t *track) messageAt(position time.Duration) *msgtype.Setpoint {
+	m := &msgtype.Setpoint{Addr: t.info.Addr}
+	if position < t.start {
+		m.Delay = uint16((t.start - position) / time.Millisecond)
+		m.Setpoints = t.values
+		return m
+	}
+	m.Setpoints = remainingSetpoints(t.values, position-t.start)
+	if len(m.Setpoints) == 0 {
+		m.Setpoints = t.values[len(t.values)-1:]
+	}
+	return m
+}
+
+// Get the message holding the actuator where the track has it at a position
+// in the timeline. Before the track starts, the actuator is held at its last
+// position reading, or put to sleep if there is none.
+func (t *track) holdAt(position time.Duration) encoding.BinaryMarshaler {
+	var p uint16
+	if position >= t.start {
+		m := &msgtype.Setpoint{t.info.Addr, 0, 0, t.values}
+		p, _ = m.ValueAt(position - t.start)
+	} else if last, ok := telemetry.position(t.info.Addr); ok {
+		p = last
+	} else {
+		return &msgtype.Sleep{t.info.Addr}
+	}
+	return &msgtype.Setpoint{t.info.Addr, 0, 0, []msgtype.SetpointValue{{msgtype.LOOP_INFINITE, p}}}
+}
+
+// Get the time taken to transmit a message at the baud rate of the serial
+// port, with ten bits to the byte.
+func transmitTime(m encoding.BinaryMarshaler) time.Duration {
+	n := 0
+	if sp, ok := m.(*msgtype.Setpoint); ok && len(sp.Setpoints) > msgtype.MaxSetpoints {
+		// only the first chunk of a long program is sent straight away
+		sp = &msgtype.Setpoint{sp.Addr, sp.Delay, sp.Loop, sp.Setpoints[:msgtype.MaxSetpoints]}
+		m = sp
+	}
+	if buf, err := m.MarshalBinary(); err == nil {
+		n = len(buf)
+	}
+	if DefaultPortConfig.Baud <= 0 {
+		return 0
+	}
+	return time.Duration(n) * 10 * time.Second / time.Duration(DefaultPortConfig.Baud)
+}
+
+// Send every track from a position in the timeline so that they start
+// together. Messages pass through the serial queue one after another, so
+// each is delayed by the time left before the last is expected to arrive.
+// Sending the first message is assumed to be quick.
+// Returns the time at which the timeline started, or would have started if
+// played from the beginning.
+func (tl *timeline) play(position time.Duration, send func(encoding.BinaryMarshaler) error, now func() time.Time) (time.Time, error) {
+	messages := make([]*msgtype.Setpoint, len(tl.tracks))
+	var lead time.Duration
+	for i, t := range tl.tracks {
+		messages[i] = t.messageAt(position)
+		lead += transmitTime(messages[i]) + SyncMargin
+	}
+
+	// allow for the time taken to send each message, as for the one before
+	start := now().Add(lead)
+	var latency time.Duration
+	for _, m := range messages {
+		wait := start.Sub(now()) - latency - transmitTime(m)
+		if wait < 0 {
+			wait = 0
+		}
+		delay := time.Duration(m.Delay)*time.Millisecond + wait
+		if delay > 0xffff*time.Millisecond {
+			delay = 0xffff * time.Millisecond
+		}
+		m.Delay = uint16(delay / time.Millisecond)
+		sentAt := now()
+		if err := send(m); err != nil {
+			return time.Time{}, err
+		}
+		latency = now().Sub(sentAt)
+	}
+	return start.Add(-position), nil
+}
+
+// Get the state of the loaded timeline and its position. Must be called
+// with timelines locked.
+func timelineState(now time.Time) (string, time.Duration) {
+	if timelines.state != TimelinePlaying {
+		return timelines.state, timelines.position
+	}
+	position := now.Sub(timelines.startedAt)
+	if position < 0 {
+		position = 0
+	} else if position >= timelines.current.duration {
+		timelines.state = TimelineStopped
+		timelines.position = timelines.current.duration
+		return timelines.state, timelines.position
+	}
+	return timelines.state, position
+}
@@ -0,0 +1,88 @@
+package cuddle
+
+import (
+	"encoding"
+	"testing"
+	"time"
+
+	"../msgtype"
+)
+
+func newTestTimeline(t *testing.T) *timeline {
+	one, two, half := uint16(1000), uint16(2000), 0.5
+	r := &timelineResource{Units: "norm", Tracks: []*trackResource{
+		{Actuator: "ribs", Setpoints: []setpointResource{{&one, &half}, {&two, &half}}},
+		{Actuator: "spine", Start: 500, Setpoints: []setpointResource{{&one, &half}}},
+	}}
+	var v validator
+	tl := r.compile(&v, "")
+	if err := v.err(); err != nil {
+		t.Fatal(err)
+	}
+	return tl
+}
+
+func TestTimelineCompile(t *testing.T) {
+	if tl := newTestTimeline(t); len(tl.tracks) != 2 || tl.duration != 3*time.Second {
+		t.Fatalf("Unexpected timeline %+v", tl)
+	}
+
+	one, half := uint16(1000), 0.5
+	r := &timelineResource{Tracks: []*trackResource{
+		{Actuator: "ribs", Setpoints: []setpointResource{{&one, &half}}},
+		{Actuator: "ribs", Setpoints: []setpointResource{{&one, &half}}},
+		{Actuator: "spine"},
+		{Actuator: "purr", Start: 65000, Setpoints: []setpointResource{{&one, &half}}},
+	}}
+	var v validator
+	r.compile(&v, "norm")
+	expect := []string{"tracks[1].actuator", "tracks[2]", "tracks[3].start"}
+	if len(v) != len(expect) {
+		t.Fatalf("Unexpected errors %v", v)
+	}
+	for i, e := range v {
+		if e.Field != expect[i] {
+			t.Fatalf("Expected error in %s, got %s", expect[i], e.Field)
+		}
+	}
+}
+
+func TestTimelinePlay(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	// each message takes a while to send; every track after the first,
+	// which cannot allow for it, should start together
+	clock := time.Unix(0, 0)
+	var sent []*msgtype.Setpoint
+	var arrived []time.Time
+	send := func(m encoding.BinaryMarshaler) error {
+		clock = clock.Add(5 * time.Millisecond)
+		sent = append(sent, m.(*msgtype.Setpoint))
+		arrived = append(arrived, clock.Add(transmitTime(m)))
+		return nil
+	}
+	now := func() time.Time { return clock }
+
+	startedAt, err := tl.play(0, send, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range sent {
+		start := arrived[i].Add(time.Duration(m.Delay) * time.Millisecond).Add(-tl.tracks[i].start)
+		if d := start.Sub(startedAt); d < -time.Millisecond || d > time.Millisecond && i > 0 || d > 6*time.Millisecond {
+			t.Fatalf("Track %d starts %v after the timeline", i, d)
+		}
+	}
+
+	// seeking into the timeline cuts the tracks short
+	sent, arrived = nil, nil
+	if _, err := tl.play(1200*time.Millisecond, send, now); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent[0].Setpoints) != 1 || sent[0].Setpoints[0].Duration != 1800 {
+		t.Fatalf("Unexpected setpoints %v", sent[0].Setpoints)
+	}
+	if len(sent[1].Setpoints) != 1 || sent[1].Setpoints[0].Duration != 300 {
+		t.Fatalf("Unexpected setpoints %v", sent[1].Setpoints)
+	}
+}
@@ -0,0 +1,615 @@
+package cuddle
+
+import (
+	"encoding"
+	"io"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"../msgtype"
+)
+
+// Default time to wait for a message to be sent or answered.
+var DefaultTimeout = time.Second
+
+// Maximum number of messages waiting to be sent to each actuator.
+const queueSize = 10
+
+var transportOut = log.New(logOut, "[transport] ", 0)
+var transportErr = log.New(logErr, "[transport] ", 0)
+
+// Transport sends messages to the actuators and matches their replies to
+// the requests that caused them.
+//
+// Each actuator has its own queue, and the queues take turns writing to the
+// port. Newer setpoint and smooth messages replace pending ones for the same
+// actuator, and sleep messages go to the front of the queue, even if it is
+// full.
+type Transport struct {
+	mu      sync.Mutex
+	queues  map[msgtype.RemoteAddress][]*request
+	order   []msgtype.RemoteAddress // order in which queues take turns
+	turn    int                     // index of the next queue to write
+	ready   chan struct{}           // signalled when a request is queued
+	pending []*request              // requests awaiting replies
+	down    bool                    // port failed and not yet reopened
+	latched bool                    // emergency stop engaged
+	port    io.Writer               // the current port
+	writeMu sync.Mutex              // held while writing to the port
+	reader  *msgtype.Reader         // reader for the current port
+}
+
+// A message waiting to be sent.
+type request struct {
+	message  encoding.BinaryMarshaler
+	addr     msgtype.RemoteAddress
+	reply    func(msgtype.Message) bool // matches the reply, or nil
+	posted   bool                       // nobody waits for the result
+	checked  bool                       // limits already checked
+	deadline time.Time
+	result   chan result
+	mu       sync.Mutex
+	done     bool
+}
+
+// The outcome of a request.
+type result struct {
+	reply msgtype.Message
+	err   error
+}
+
+// The transport used by the server.
+var DefaultTransport = NewTransport()
+
+// Create a new transport.
+func NewTransport() *Transport {
+	return &Transport{
+		queues: make(map[msgtype.RemoteAddress][]*request),
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+// Send messages to the port and read replies from it until either fails.
+func RunTransport(p io.ReadWriter) error {
+	return DefaultTransport.Run(p)
+}
+
+// Queue a message and wait until it is written to the port.
+func Send(m encoding.BinaryMarshaler) error {
+	return DefaultTransport.Send(m, DefaultTimeout)
+}
+
+// Queue a message and wait for the reply.
+func Request(m encoding.BinaryMarshaler) (msgtype.Message, error) {
+	return DefaultTransport.Request(m, DefaultTimeout)
+}
+
+// Send messages to the port and read replies from it until either fails.
+// Requests made after the port fails are rejected until Run is called again.
+func (t *Transport) Run(p io.ReadWriter) error {
+	return t.run(p, nil)
+}
+
+// Run the transport, calling up in its own goroutine once requests are
+// accepted, if given.
+func (t *Transport) run(p io.ReadWriter, up func()) error {
+	rd := msgtype.NewReader(p)
+
+	t.mu.Lock()
+	t.down = false
+	t.port = p
+	t.reader = rd
+	t.mu.Unlock()
+
+	if up != nil {
+		go up()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- t.receive(rd)
+	}()
+
+	for {
+		select {
+		case err := <-done:
+			t.fail(err)
+			return err
+		default:
+		}
+
+		r := t.dequeue()
+		if r == nil {
+			select {
+			case err := <-done:
+				t.fail(err)
+				return err
+			case <-t.ready:
+			}
+			continue
+		}
+
+		if err := t.write(p, r); err != nil {
+			t.fail(err)
+			return err
+		}
+	}
+}
+
+// Get statistics for the reader on the current port.
+func (t *Transport) ReaderStats() msgtype.ReaderStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.reader == nil {
+		return msgtype.ReaderStats{}
+	}
+	return t.reader.Stats()
+}
+
+// Queue a message and wait until it is written to the port.
+func (t *Transport) Send(m encoding.BinaryMarshaler, timeout time.Duration) error {
+	_, err := t.do(t.newRequest(m, nil, timeout), timeout)
+	return err
+}
+
+// Queue a message whose limits were checked as part of a larger whole, such
+// as a chunk of a long program, and wait until it is written to the port.
+func (t *Transport) sendChecked(m encoding.BinaryMarshaler, timeout time.Duration) error {
+	r := t.newRequest(m, nil, timeout)
+	r.checked = true
+	_, err := t.do(r, timeout)
+	return err
+}
+
+// Queue a message and wait for the reply.
+func (t *Transport) Request(m encoding.BinaryMarshaler, timeout time.Duration) (msgtype.Message, error) {
+	match := replyMatcher(m)
+	if match == nil {
+		return nil, InvalidMessageError
+	}
+	return t.do(t.newRequest(m, match, timeout), timeout)
+}
+
+// Queue a message without waiting for it to be sent.
+// Messages of the same type already waiting for the actuator are not
+// queued again.
+func (t *Transport) Post(m encoding.BinaryMarshaler) error {
+	r := t.newRequest(m, nil, DefaultTimeout)
+	r.posted = true
+	return t.enqueue(r)
+}
+
+func (t *Transport) newRequest(m encoding.BinaryMarshaler, match func(msgtype.Message) bool, timeout time.Duration) *request {
+	return &request{
+		message:  m,
+		addr:     msgtype.AddressOf(m),
+		reply:    match,
+		deadline: time.Now().Add(timeout),
+		result:   make(chan result, 1),
+	}
+}
+
+// Queue a request and wait for the result.
+func (t *Transport) do(r *request, timeout time.Duration) (msgtype.Message, error) {
+	if err := t.enqueue(r); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-r.result:
+		return res.reply, res.err
+	case <-timer.C:
+		// the reply may arrive just as the timer fires
+		if !r.finish(result{err: TimeoutError}) {
+			res := <-r.result
+			return res.reply, res.err
+		}
+		t.remove(r)
+		return nil, TimeoutError
+	}
+}
+
+// Add a request to the queue for its actuator.
+func (t *Transport) enqueue(r *request) error {
+	capability := msgtype.CapabilityOf(r.message)
+	if a, ok := msgtype.LookupAddress(r.addr); ok && !a.Can(capability) {
+		return NotSupportedError.withDetail("%s cannot %s", a.Name, capability)
+	}
+	if !r.checked {
+		if err := CheckLimits(r.message); err != nil {
+			return err
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.down {
+		return SerialDownError
+	}
+	if t.latched && isRestricted(r.message) {
+		return EmergencyStopError
+	}
+
+	q, ok := t.queues[r.addr]
+	if !ok {
+		t.order = append(t.order, r.addr)
+	}
+
+	switch {
+	case r.posted && queued(q, r.message):
+		return nil
+
+	case isSleep(r.message):
+		// sleep replaces pending motion and goes to the front, and is
+		// always accepted, making room in a full queue by dropping the
+		// oldest request that is not a sleep
+		var superseded []*request
+		q, superseded = filterQueue(q, isMotion)
+		if len(q) >= queueSize {
+			for i, old := range q {
+				if !isSleep(old.message) {
+					superseded = append(superseded, old)
+					q = append(q[:i:i], q[i+1:]...)
+					break
+				}
+			}
+		}
+		for _, old := range superseded {
+			old.finish(result{err: SupersededError})
+		}
+		q = append([]*request{r}, q...)
+
+	case isMotion(r.message):
+		// replace pending motion in place
+		replaced := false
+		for i, old := range q {
+			if isMotion(old.message) {
+				old.finish(result{err: SupersededError})
+				q[i] = r
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			if len(q) >= queueSize {
+				return QueueFullError
+			}
+			q = append(q, r)
+		}
+
+	default:
+		if len(q) >= queueSize {
+			return QueueFullError
+		}
+		q = append(q, r)
+	}
+
+	t.queues[r.addr] = q
+
+	select {
+	case t.ready <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Take the next request to write. Sleep messages are taken first, then the
+// actuator queues take turns.
+func (t *Transport) dequeue() *request {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, addr := range t.order {
+		if q := t.queues[addr]; len(q) > 0 && isSleep(q[0].message) {
+			t.queues[addr] = q[1:]
+			return q[0]
+		}
+	}
+
+	for i := range t.order {
+		addr := t.order[(t.turn+i)%len(t.order)]
+		if q := t.queues[addr]; len(q) > 0 {
+			t.queues[addr] = q[1:]
+			t.turn = (t.turn + i + 1) % len(t.order)
+			return q[0]
+		}
+	}
+
+	return nil
+}
+
+// Write a request to the port. Only errors writing to the port are
+// returned; other errors are reported to the request.
+func (t *Transport) write(p io.Writer, r *request) error {
+	if time.Now().After(r.deadline) {
+		r.finish(result{err: TimeoutError})
+		return nil
+	}
+
+	buf, err := r.message.MarshalBinary()
+	if err != nil {
+		transportErr.Printf("Failed marshal message %s %v", err.Error(), r.message)
+		r.finish(result{err: InvalidMessageError.withDetail("%s", err.Error())})
+		return nil
+	}
+
+	// wait for the reply before writing so it cannot be missed
+	if r.reply != nil {
+		t.mu.Lock()
+		t.pending = append(t.pending, r)
+		t.mu.Unlock()
+	}
+
+	t.writeMu.Lock()
+	// the emergency stop may have been engaged after the request was queued
+	if isRestricted(r.message) && t.Latched() {
+		t.writeMu.Unlock()
+		t.remove(r)
+		r.finish(result{err: EmergencyStopError})
+		return nil
+	}
+	_, err = p.Write(buf)
+	t.writeMu.Unlock()
+
+	if err != nil {
+		transportErr.Printf("Failed to send message %s %x", err.Error(), buf)
+		t.remove(r)
+		r.finish(result{err: SerialDownError})
+		return err
+	}
+
+	telemetry.sent(r.message)
+	uploadSent(r.message)
+	recordSent(r.message, buf)
+	if Debug {
+		transportOut.Printf("Completed message send %x", buf)
+	}
+
+	if r.reply == nil {
+		r.finish(result{})
+	}
+
+	return nil
+}
+
+// Read messages from the port, recording telemetry and answering requests.
+func (t *Transport) receive(rd *msgtype.Reader) error {
+	for {
+		frame, err := rd.ReadFrame()
+		if err != nil {
+			transportErr.Printf("Failed to read message %s", err.Error())
+			return err
+		}
+		m, err := frame.Message()
+		if err != nil {
+			transportErr.Printf("Failed to decode message %s %x",
+				err.Error(), []byte(frame))
+			continue
+		}
+		telemetry.received(m)
+		recordReceived(m, frame)
+		t.answer(m)
+	}
+}
+
+// Deliver a reply to the oldest matching request.
+func (t *Transport) answer(m msgtype.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, r := range t.pending {
+		if r.reply(m) {
+			t.pending = append(t.pending[:i], t.pending[i+1:]...)
+			r.finish(result{reply: m})
+			return
+		}
+	}
+}
+
+// Remove a request from its queue and stop waiting for its reply.
+func (t *Transport) remove(r *request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	q := t.queues[r.addr]
+	for i, p := range q {
+		if p == r {
+			t.queues[r.addr] = append(q[:i:i], q[i+1:]...)
+			break
+		}
+	}
+
+	for i, p := range t.pending {
+		if p == r {
+			t.pending = append(t.pending[:i], t.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// Check whether the port has failed.
+func (t *Transport) isDown() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.down
+}
+
+// Mark the port as failed, failing all queued requests and requests
+// awaiting replies.
+func (t *Transport) fail(err error) {
+	t.mu.Lock()
+	pending := t.pending
+	for addr, q := range t.queues {
+		pending = append(pending, q...)
+		delete(t.queues, addr)
+	}
+	t.order = nil
+	t.turn = 0
+	t.pending = nil
+	t.down = true
+	t.port = nil
+	t.mu.Unlock()
+
+	for _, r := range pending {
+		r.finish(result{err: SerialDownError})
+	}
+}
+
+// Report the result of a request, if not already done. Returns false if the
+// request was already finished.
+func (r *request) finish(res result) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.done {
+		return false
+	}
+	r.done = true
+	r.result <- res
+	return true
+}
+
+// Sleep every known actuator immediately, bypassing the queues, and latch the
+// emergency stop. Queued motion and PID messages are discarded, and new ones
+// are rejected until the emergency stop is reset.
+func (t *Transport) EmergencyStop() error {
+	t.mu.Lock()
+	t.latched = true
+	var stopped []*request
+	for addr, q := range t.queues {
+		var removed []*request
+		t.queues[addr], removed = filterQueue(q, isRestricted)
+		stopped = append(stopped, removed...)
+	}
+	addrs := msgtype.Addresses()
+	for _, addr := range t.order {
+		if !containsAddress(addrs, addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	p := t.port
+	t.mu.Unlock()
+
+	for _, r := range stopped {
+		r.finish(result{err: EmergencyStopError})
+	}
+
+	if p == nil {
+		return SerialDownError
+	}
+
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	var err error
+	for _, addr := range addrs {
+		m := &msgtype.Sleep{addr}
+		buf, _ := m.MarshalBinary()
+		if _, werr := p.Write(buf); werr != nil {
+			transportErr.Printf("Failed to send emergency stop %s %x", werr.Error(), buf)
+			err = SerialDownError
+			continue
+		}
+		telemetry.sent(m)
+		uploadSent(m)
+		recordSent(m, buf)
+	}
+
+	return err
+}
+
+// Reset the emergency stop, allowing motion again.
+func (t *Transport) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.latched = false
+}
+
+// Check whether the emergency stop is engaged.
+func (t *Transport) Latched() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.latched
+}
+
+// Check whether a message of the same type is in a queue.
+func queued(q []*request, m encoding.BinaryMarshaler) bool {
+	for _, r := range q {
+		if reflect.TypeOf(r.message) == reflect.TypeOf(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove matching messages from a queue, returning the new queue and the
+// removed requests.
+func filterQueue(q []*request, match func(encoding.BinaryMarshaler) bool) ([]*request, []*request) {
+	var keep, removed []*request
+	for _, r := range q {
+		if match(r.message) {
+			removed = append(removed, r)
+		} else {
+			keep = append(keep, r)
+		}
+	}
+	return keep, removed
+}
+
+// Check whether a message moves an actuator.
+func isMotion(m encoding.BinaryMarshaler) bool {
+	switch m.(type) {
+	case *msgtype.Setpoint, *msgtype.Smooth:
+		return true
+	}
+	return false
+}
+
+// Check whether a message is rejected while the emergency stop is engaged.
+func isRestricted(m encoding.BinaryMarshaler) bool {
+	_, pid := m.(*msgtype.SetPID)
+	return pid || isMotion(m)
+}
+
+func containsAddress(addrs []msgtype.RemoteAddress, addr msgtype.RemoteAddress) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// Check whether a message stops an actuator.
+func isSleep(m encoding.BinaryMarshaler) bool {
+	_, ok := m.(*msgtype.Sleep)
+	return ok
+}
+
+// Get a function matching the reply to a message, or nil if the message has
+// no reply.
+func replyMatcher(message encoding.BinaryMarshaler) func(msgtype.Message) bool {
+	switch m := message.(type) {
+	case *msgtype.Ping:
+		return func(reply msgtype.Message) bool {
+			pong, ok := reply.(*msgtype.Pong)
+			return ok && pong.Addr == m.Addr
+		}
+	case *msgtype.Value:
+		return func(reply msgtype.Message) bool {
+			reading, ok := reply.(*msgtype.Reading)
+			return ok && reading.Addr == m.Addr
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package cuddle
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"../msgtype"
+)
+
+func TestTransport(t *testing.T) {
+	server, board := Pipe()
+	defer server.Close()
+	defer board.Close()
+
+	// answer pings, ignore everything else
+	go func() {
+		r := msgtype.NewReader(board)
+		for {
+			m, err := r.ReadMessage()
+			if err != nil {
+				return
+			}
+			if ping, ok := m.(*msgtype.Ping); ok {
+				buf, _ := (&msgtype.Pong{ping.Addr}).MarshalBinary()
+				board.Write(buf)
+			}
+		}
+	}()
+
+	tr := NewTransport()
+	go tr.Run(server)
+
+	if reply, err := tr.Request(&msgtype.Ping{msgtype.RibsAddress}, time.Second); err != nil {
+		t.Fatal(err)
+	} else if pong, ok := reply.(*msgtype.Pong); !ok || pong.Addr != msgtype.RibsAddress {
+		t.Fatalf("Expected pong from ribs, got %v", reply)
+	}
+
+	if err := tr.Send(&msgtype.Sleep{msgtype.RibsAddress}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tr.Request(&msgtype.Value{msgtype.RibsAddress}, 50*time.Millisecond); err != TimeoutError {
+		t.Fatalf("Expected %v, got %v", TimeoutError, err)
+	}
+
+	if _, err := tr.Request(&msgtype.Sleep{msgtype.RibsAddress}, time.Second); err != InvalidMessageError {
+		t.Fatalf("Expected %v, got %v", InvalidMessageError, err)
+	}
+}
+
+func TestTransportQueues(t *testing.T) {
+	tr := NewTransport()
+	post := func(m msgtype.Message) *request {
+		r := tr.newRequest(m, nil, time.Second)
+		if err := tr.enqueue(r); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	head1 := post(&msgtype.Setpoint{Addr: msgtype.HeadXAddress})
+	head2 := post(&msgtype.Smooth{Addr: msgtype.HeadXAddress})
+	pid := post(&msgtype.SetPID{Addr: msgtype.HeadXAddress})
+	ribs := post(&msgtype.Setpoint{Addr: msgtype.RibsAddress})
+	sleep := post(&msgtype.Sleep{msgtype.RibsAddress})
+
+	// newer motion replaces pending motion
+	if res := <-head1.result; res.err != SupersededError {
+		t.Fatalf("Expected %v, got %v", SupersededError, res.err)
+	}
+	// sleep replaces pending motion
+	if res := <-ribs.result; res.err != SupersededError {
+		t.Fatalf("Expected %v, got %v", SupersededError, res.err)
+	}
+
+	// sleep first, then actuators take turns
+	for i, expect := range []*request{sleep, head2, pid, nil} {
+		if r := tr.dequeue(); r != expect {
+			t.Fatalf("Expected request %d to be %v, got %v", i, expect, r)
+		}
+	}
+
+	var pings []*request
+	for i := 0; i < queueSize; i++ {
+		pings = append(pings, post(&msgtype.Ping{msgtype.PurrAddress}))
+	}
+	if err := tr.enqueue(tr.newRequest(&msgtype.Ping{msgtype.PurrAddress}, nil, time.Second)); err != QueueFullError {
+		t.Fatalf("Expected %v, got %v", QueueFullError, err)
+	}
+	// sleep is accepted into a full queue, dropping the oldest request
+	purrSleep := post(&msgtype.Sleep{msgtype.PurrAddress})
+	if res := <-pings[0].result; res.err != SupersededError {
+		t.Fatalf("Expected %v, got %v", SupersededError, res.err)
+	}
+	if q := tr.queues[msgtype.PurrAddress]; len(q) != queueSize || q[0] != purrSleep {
+		t.Fatalf("Expected sleep first in a full queue, got %d requests", len(q))
+	}
+	// a full queue does not block other actuators
+	post(&msgtype.Ping{msgtype.SpineAddress})
+
+	// queues start afresh after the port fails and is reopened
+	tr.fail(io.EOF)
+	tr.down = false
+	post(&msgtype.Ping{msgtype.SpineAddress})
+	if len(tr.order) != 1 || tr.order[0] != msgtype.SpineAddress {
+		t.Fatalf("Unexpected queue order %v", tr.order)
+	}
+}
+
+func TestEmergencyStop(t *testing.T) {
+	server, board := Pipe()
+	defer server.Close()
+	defer board.Close()
+
+	sleeps := make(chan msgtype.RemoteAddress, 10)
+	go func() {
+		r := msgtype.NewReader(board)
+		for {
+			m, err := r.ReadMessage()
+			if err != nil {
+				return
+			}
+			if sleep, ok := m.(*msgtype.Sleep); ok {
+				sleeps <- sleep.Addr
+			}
+		}
+	}()
+
+	tr := NewTransport()
+	go tr.Run(server)
+
+	// wait until the port is running
+	if err := tr.Send(&msgtype.Sleep{msgtype.RibsAddress}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if addr := <-sleeps; addr != msgtype.RibsAddress {
+		t.Fatalf("Expected sleep for ribs, got %q", rune(addr))
+	}
+
+	if err := tr.EmergencyStop(); err != nil {
+		t.Fatal(err)
+	}
+	for _ = range msgtype.Addresses() {
+		<-sleeps
+	}
+
+	setpoint := &msgtype.Setpoint{msgtype.SpineAddress, 0, 0, []msgtype.SetpointValue{
+		msgtype.SetpointValue{Duration: 100, Setpoint: 100},
+	}}
+	if err := tr.Send(setpoint, time.Second); err != EmergencyStopError {
+		t.Fatalf("Expected %v, got %v", EmergencyStopError, err)
+	}
+	if err := tr.Send(&msgtype.Sleep{msgtype.SpineAddress}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	tr.Reset()
+	if err := tr.Send(setpoint, time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,37 @@
+package cuddle
+
+import (
+	"../msgtype"
+)
+
+// Check that units are known, if given.
+func checkUnits(units string) error {
+	for _, u := range msgtype.Units {
+		if units == "" || units == u {
+			return nil
+		}
+	}
+	return InvalidUnitsError.withDetail("%q", units)
+}
+
+// Get the units of a version 1 request, which are raw unless given, so that
+// the units configured for an actuator do not change what existing clients
+// mean.
+func v1Units(units string) string {
+	if units == "" {
+		return msgtype.UnitsRaw
+	}
+	return units
+}
+
+// Convert a position, if known, to the given units.
+func decodePosition(addr msgtype.RemoteAddress, p *uint16, units string) *float64 {
+	if p == nil {
+		return nil
+	}
+	v, err := msgtype.DecodePosition(addr, *p, units)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
@@ -0,0 +1,205 @@
+package cuddle
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+
+	"../msgtype"
+)
+
+// Errors found while validating a request, in the order found.
+type validator []*Error
+
+// Add an error.
+func (v *validator) add(e *Error) {
+	*v = append(*v, e)
+}
+
+// Add a missing field error unless the field is present.
+func (v *validator) require(field string, present bool) bool {
+	if !present {
+		v.add(missingField(field))
+	}
+	return present
+}
+
+// Check that units are known, if given.
+func (v *validator) units(field, units string) bool {
+	if checkUnits(units) != nil {
+		v.add(InvalidUnitsError.withField(field, "%q", units))
+		return false
+	}
+	return true
+}
+
+// Check a duration in ms and a position in the given units, converting them
+// to a setpoint value for the actuator at an address.
+func (v *validator) setpoint(durationField, positionField string, addr msgtype.RemoteAddress, d, p float64, units string) msgtype.SetpointValue {
+	if d < 0 || d > 0xffff || d != math.Floor(d) {
+		v.add(InvalidSetpointError.withField(durationField, "duration %v out of range", d))
+	}
+	sp, err := msgtype.EncodePosition(addr, p, units)
+	if err != nil {
+		v.add(InvalidSetpointError.withField(positionField, "position %v out of range", p))
+	}
+	return msgtype.SetpointValue{uint16(d), sp}
+}
+
+// Check a flat list of duration and position pairs, converting them to
+// setpoint values for the actuator at an address.
+func (v *validator) setpoints(field string, addr msgtype.RemoteAddress, pairs []float64, units string) []msgtype.SetpointValue {
+	if len(pairs) == 0 {
+		v.add(InvalidSetpointError.withField(field, "expected duration and position pairs"))
+		return nil
+	} else if len(pairs)%2 != 0 {
+		v.add(InvalidSetpointError.withField(field,
+			"expected duration and position pairs, got %d values", len(pairs)))
+		return nil
+	}
+
+	values := make([]msgtype.SetpointValue, len(pairs)/2)
+	for i := range values {
+		values[i] = v.setpoint(
+			fmt.Sprintf("%s[%d]", field, 2*i), fmt.Sprintf("%s[%d]", field, 2*i+1),
+			addr, pairs[2*i], pairs[2*i+1], units)
+	}
+	return values
+}
+
+// Get the first error found, listing every error if there were several.
+func (v validator) err() error {
+	switch len(v) {
+	case 0:
+		return nil
+	case 1:
+		return v[0]
+	}
+	c := *v[0]
+	c.Errors = v
+	return &c
+}
+
+// Decode a JSON request body into a struct. Unknown and ill-typed fields are
+// all reported, with the field at fault.
+func decodeBody(body io.Reader, v interface{}) error {
+	var raw json.RawMessage
+	if err := json.NewDecoder(body).Decode(&raw); err == io.EOF {
+		return InvalidMessageError.withDetail("empty body")
+	} else if err != nil {
+		return InvalidMessageError.withDetail("%s", err.Error())
+	}
+
+	var errs validator
+	errs.decode("", raw, reflect.ValueOf(v).Elem())
+	return errs.err()
+}
+
+// Decode a JSON value into a value of the request. Structs and slices of
+// structs are decoded field by field; null leaves the value unset.
+func (v *validator) decode(field string, raw json.RawMessage, rv reflect.Value) {
+	if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return
+	}
+
+	switch {
+	case rv.Kind() == reflect.Ptr:
+		p := reflect.New(rv.Type().Elem())
+		v.decode(field, raw, p.Elem())
+		rv.Set(p)
+	case rv.Kind() == reflect.Struct && !isUnmarshaler(rv):
+		v.decodeStruct(field, raw, rv)
+	case rv.Kind() == reflect.Slice && !isUnmarshaler(rv) && isStructLike(rv.Type().Elem()):
+		var list []json.RawMessage
+		if err := json.Unmarshal(raw, &list); err != nil {
+			v.add(InvalidMessageError.withField(field, "expected array"))
+			return
+		}
+		rv.Set(reflect.MakeSlice(rv.Type(), len(list), len(list)))
+		for i, r := range list {
+			v.decode(fmt.Sprintf("%s[%d]", field, i), r, rv.Index(i))
+		}
+	default:
+		err := json.Unmarshal(raw, rv.Addr().Interface())
+		if e, ok := err.(*json.UnmarshalTypeError); ok {
+			v.add(InvalidMessageError.withField(field, "expected %s, got %s", e.Type, e.Value))
+		} else if err == msgtype.InvalidAddressError {
+			v.add(InvalidAddressError.withField(field, "%s", raw))
+		} else if err != nil {
+			v.add(InvalidMessageError.withField(field, "%s", err.Error()))
+		}
+	}
+}
+
+// Decode a JSON object into a struct by the json names of its fields. As
+// with encoding/json, keys match names exactly or else ignoring case.
+func (v *validator) decodeStruct(field string, raw json.RawMessage, rv reflect.Value) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &object); err != nil {
+		v.add(InvalidMessageError.withField(field, "expected object"))
+		return
+	}
+
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		r, ok := object[name]
+		for _, key := range keys {
+			if _, left := object[key]; left && strings.EqualFold(key, name) {
+				if !ok {
+					r, ok = object[key], true
+				}
+				delete(object, key)
+			}
+		}
+		if ok {
+			v.decode(join(field, name), r, rv.Field(i))
+		}
+	}
+
+	for _, key := range keys {
+		if _, unknown := object[key]; unknown {
+			v.add(InvalidMessageError.withField(join(field, key), "unknown field"))
+		}
+	}
+}
+
+// Check whether a value decodes itself from JSON.
+func isUnmarshaler(rv reflect.Value) bool {
+	switch rv.Addr().Interface().(type) {
+	case json.Unmarshaler, encoding.TextUnmarshaler:
+		return true
+	}
+	return false
+}
+
+// Check whether a type is a struct or pointer to a struct.
+func isStructLike(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
+
+// Join the name of a field to that of the enclosing object.
+func join(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
@@ -0,0 +1,140 @@
+package cuddle
+
+import (
+	"strings"
+	"testing"
+
+	"../msgtype"
+)
+
+// Get the fields at fault in an error.
+func faultyFields(err error) []string {
+	e, ok := err.(*Error)
+	if !ok {
+		return nil
+	} else if e.Errors == nil {
+		return []string{e.Field}
+	}
+	fields := make([]string, len(e.Errors))
+	for i, f := range e.Errors {
+		fields[i] = f.Field
+	}
+	return fields
+}
+
+func TestDecodeBody(t *testing.T) {
+	cases := []struct {
+		body   string
+		fields string // fields at fault, or - for a malformed body
+	}{
+		{`{"addr": "ribs", "kp": 1, "ki": 2, "kd": 3}`, ""},
+		{`{"addr": "ribs", "kp": 1, "ki": 2, "kd": 3, "kx": 4}`, "kx"},
+		{`{"addr": "tail", "kp": "one", "ki": 2, "kd": []}`, "addr kp kd"},
+		{`{"addr": "ribs", "kp": null, "ki": 2, "kd": 3}`, ""},
+		{`{"Addr": "ribs", "Kp": 1, "KI": 2, "kd": 3}`, ""},
+		{`{"Addr": "ribs", "kp": 1, "ki": 2, "kd": 3, "Kx": 4}`, "Kx"},
+		{`[1, 2]`, "-"},
+		{``, "-"},
+	}
+
+	for _, c := range cases {
+		var data setpidMessage
+		err := decodeBody(strings.NewReader(c.body), &data)
+		if err == nil && c.fields != "" {
+			t.Errorf("%s: expected error", c.body)
+		} else if err != nil && c.fields == "" {
+			t.Errorf("%s: %v", c.body, err)
+		} else if err != nil && c.fields != "-" {
+			if fields := strings.Join(faultyFields(err), " "); fields != c.fields {
+				t.Errorf("%s: expected errors in %q, got %q", c.body, c.fields, fields)
+			}
+		}
+	}
+}
+
+func TestDecodeBodyCase(t *testing.T) {
+	var data setpidMessage
+	if err := decodeBody(strings.NewReader(`{"Addr": "ribs", "KP": 1, "Ki": 2, "kd": 4}`), &data); err != nil {
+		t.Fatal(err)
+	}
+	if *data.Addr != msgtype.RibsAddress || *data.Kp != 1 || *data.Ki != 2 || *data.Kd != 4 {
+		t.Fatalf("Unexpected values %+v", data)
+	}
+}
+
+func TestSetpointValuesMissing(t *testing.T) {
+	var v validator
+	setpointValues(&v, "", msgtype.RibsAddress, []setpointResource{{}}, "")
+	if fields := strings.Join(faultyFields(v.err()), " "); fields != "setpoints[0].duration setpoints[0].position" {
+		t.Fatalf("Unexpected errors in %q", fields)
+	}
+}
+
+func TestDecodeBodyNested(t *testing.T) {
+	var data actuatorUpdate
+	err := decodeBody(strings.NewReader(`{"setpoints": {"setpoints": [
+		{"duration": 1, "position": 2},
+		{"duration": -1, "position": 2, "speed": 3}
+	]}}`), &data)
+	fields := strings.Join(faultyFields(err), " ")
+	if fields != "setpoints.setpoints[1].duration setpoints.setpoints[1].speed" {
+		t.Fatalf("unexpected errors in %q: %v", fields, err)
+	}
+}
+
+func TestBind(t *testing.T) {
+	var setpid msgtype.SetPID
+	err := (&setpidMessage{}).bind(&setpid)
+	if fields := strings.Join(faultyFields(err), " "); fields != "addr kp ki kd" {
+		t.Errorf("setpid: unexpected errors in %q", fields)
+	}
+
+	addr := msgtype.RemoteAddress(msgtype.RibsAddress)
+	loop := uint16(0)
+	var setpoint msgtype.Setpoint
+	err = (&setpointMessage{&addr, 0, &loop, &[]float64{100, 1, 100}, ""}).bind(&setpoint)
+	if fields := strings.Join(faultyFields(err), " "); fields != "setpoints" {
+		t.Errorf("setpoint: unexpected errors in %q", fields)
+	}
+	err = (&setpointMessage{&addr, 0, &loop, &[]float64{100, 1, 70000, 1.5}, ""}).bind(&setpoint)
+	if fields := strings.Join(faultyFields(err), " "); fields != "setpoints[2] setpoints[3]" {
+		t.Errorf("setpoint: unexpected errors in %q", fields)
+	}
+
+	time := uint16(10)
+	var smooth msgtype.Smooth
+	err = (&smoothMessage{&addr, &time, &[]float64{100}, ""}).bind(&smooth)
+	if fields := strings.Join(faultyFields(err), " "); fields != "setpoint" {
+		t.Errorf("smooth: unexpected errors in %q", fields)
+	}
+	if err := (&smoothMessage{&addr, &time, &[]float64{100, 1, 200, 2}, ""}).bind(&smooth); err != nil {
+		t.Errorf("smooth: %v", err)
+	} else if len(smooth.Setpoint) != 2 {
+		t.Errorf("smooth: expected 2 setpoints, got %v", smooth.Setpoint)
+	}
+	long := make([]float64, 2*msgtype.MaxSetpoints+2)
+	err = (&smoothMessage{&addr, &time, &long, ""}).bind(&smooth)
+	if fields := strings.Join(faultyFields(err), " "); fields != "setpoint" {
+		t.Errorf("smooth: unexpected errors in %q", fields)
+	}
+
+	// version 1 positions are raw unless the request gives units
+	list := msgtype.DefaultActuators()
+	for i := range list {
+		list[i].Units = msgtype.UnitsDegrees
+	}
+	if err := msgtype.SetActuators(list); err != nil {
+		t.Fatal(err)
+	}
+	defer msgtype.SetActuators(msgtype.DefaultActuators())
+	if err := (&setpointMessage{&addr, 0, &loop, &[]float64{100, 1000}, ""}).bind(&setpoint); err != nil {
+		t.Errorf("setpoint: %v", err)
+	} else if setpoint.Setpoints[0].Setpoint != 1000 {
+		t.Errorf("setpoint: expected raw position 1000, got %v", setpoint.Setpoints)
+	}
+	if err := (&smoothMessage{&addr, &time, &[]float64{100, 1000}, ""}).bind(&smooth); err != nil {
+		t.Errorf("smooth: %v", err)
+	} else if smooth.Setpoint[0].Setpoint != 1000 {
+		t.Errorf("smooth: expected raw position 1000, got %v", smooth.Setpoint)
+	}
+}
@@ -12,6 +12,8 @@ import (
 	"flag"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/stretchr/graceful"
@@ -19,16 +21,24 @@ import (
 	"../cuddle"
 )
 
-func main() {
-	l := log.New(os.Stdout, "[cuddled] ", 0)
-	e := log.New(os.Stderr, "[cuddled] ", 0)
+var (
+	defaults = cuddle.DefaultConfig()
 
-	// define flags
-	debug := flag.Bool("debug", false, "print debug messages")
-	help := flag.Bool("help", false, "print help")
-	portname := flag.String("port", "/dev/ttyUSB0", "the serial port name")
-	listenaddr := flag.String("listen", ":http", "the address on which to listen")
+	configname   = flag.String("config", "", "a TOML configuration file, reloaded on SIGHUP")
+	debug        = flag.Bool("debug", false, "print debug messages")
+	help         = flag.Bool("help", false, "print help")
+	portname     = flag.String("port", defaults.Serial.Port, "the serial port name")
+	baud         = flag.Int("baud", defaults.Serial.Baud, "the serial port baud rate")
+	listenaddr   = flag.String("listen", defaults.Listen, "the address on which to listen")
+	limitsname   = flag.String("limits", "", "a JSON file of actuator limits")
+	restore      = flag.Bool("restore", false, "resend PID coefficients and running setpoints after reconnecting")
+	leaseTimeout = flag.Duration("lease-timeout", defaults.LeaseTimeout.Duration, "the default time before an unrenewed client lease expires")
+	poll         = flag.Duration("poll", defaults.Poll.Duration, "the interval at which to read actuator positions, or 0 to disable")
+	behaviours   = flag.String("behaviours", "", "a directory of behaviour files")
+	record       = flag.String("record", "", "a file to which to record sessions")
+)
 
+func main() {
 	// parse flags
 	flag.Parse()
 
@@ -44,22 +54,125 @@ func main() {
 		os.Exit(1)
 	}
 
-	// connect serial port
-	port, err := cuddle.OpenPort(*portname)
+	// load configuration
+	config, err := loadConfig()
 	if err != nil {
-		e.Fatalln(err)
+		log.Fatalln(err)
+	}
+	if err := config.Apply(); err != nil {
+		log.Fatalln(err)
+	}
+
+	// load limits
+	if *limitsname != "" {
+		if err := loadLimits(*limitsname); err != nil {
+			log.Fatalln(err)
+		}
 	}
-	defer port.Close()
-	l.Println("Connected to", *portname)
 
-	// update setpoints in background
-	go cuddle.SendQueuedMessagesTo(port)
+	// connect serial port, reconnecting in background
+	serial := config.Serial
+	cuddle.DefaultPortConfig.Baud = serial.Baud
+	cuddle.DefaultPortConfig.LowLatency = serial.LowLatency
+	go cuddle.Supervise(func() (cuddle.Port, error) {
+		return cuddle.OpenPort(serial.Port)
+	}, serial.Restore)
+
+	// read actuator positions in background
+	if config.Poll.Duration > 0 {
+		go cuddle.PollPositions(config.Poll.Duration)
+	}
+
+	// reload configuration on hangup
+	go reloadOnHangup(config)
 
-	// set debug
-	cuddle.Debug = *debug
 	// create server instance
 	mux := cuddle.New()
 
 	// run with graceful shutdown
-	graceful.Run(*listenaddr, time.Second, mux)
+	graceful.Run(config.Listen, time.Second, mux)
+}
+
+// Read the configuration file, if any, overriding it with flags given on the
+// command line.
+func loadConfig() (*cuddle.Config, error) {
+	config := cuddle.DefaultConfig()
+	if *configname != "" {
+		var err error
+		if config, err = cuddle.LoadConfig(*configname); err != nil {
+			return nil, err
+		}
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "debug":
+			config.Log.Debug = *debug
+		case "port":
+			config.Serial.Port = *portname
+		case "baud":
+			config.Serial.Baud = *baud
+		case "listen":
+			config.Listen = *listenaddr
+		case "restore":
+			config.Serial.Restore = *restore
+		case "lease-timeout":
+			config.LeaseTimeout.Duration = *leaseTimeout
+		case "poll":
+			config.Poll.Duration = *poll
+		case "behaviours":
+			config.Behaviours = *behaviours
+		case "record":
+			config.Log.Record = *record
+		}
+	})
+
+	return config, nil
+}
+
+// Reload the configuration on SIGHUP. The serial connection is kept open;
+// settings that need a restart are reported but not applied.
+func reloadOnHangup(running *cuddle.Config) {
+	l := log.New(cuddle.LogOut, "[cuddled] ", 0)
+	e := log.New(cuddle.LogErr, "[cuddled] ", 0)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for _ = range hup {
+		config, err := loadConfig()
+		if err != nil {
+			e.Println("Failed to reload configuration", err.Error())
+			continue
+		}
+		if err := config.Apply(); err != nil {
+			e.Println("Failed to apply configuration", err.Error())
+			continue
+		}
+		if *limitsname != "" {
+			if err := loadLimits(*limitsname); err != nil {
+				e.Println("Failed to reload limits", err.Error())
+			}
+		}
+
+		if config.Serial != running.Serial {
+			e.Println("Serial port settings changed, restart to apply")
+		}
+		if config.Listen != running.Listen {
+			e.Println("Listen address changed, restart to apply")
+		}
+		if config.Poll != running.Poll {
+			e.Println("Poll interval changed, restart to apply")
+		}
+		l.Println("Reloaded configuration")
+	}
+}
+
+func loadLimits(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return cuddle.LoadLimits(f)
 }
@@ -0,0 +1,88 @@
+/*
+
+CuddleSim simulates the Cuddlebot actuators on a pseudo-terminal.
+
+Point cuddled or cuddlespeak at the printed port name to run them without
+the robot.
+
+*/
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"../cuddle"
+	"../sim"
+)
+
+func main() {
+	l := log.New(os.Stdout, "[cuddlesim] ", 0)
+	e := log.New(os.Stderr, "[cuddlesim] ", 0)
+
+	// define flags
+	debug := flag.Bool("debug", false, "print debug messages")
+	help := flag.Bool("help", false, "print help")
+	link := flag.String("link", "", "create a symlink to the port with this name")
+	configname := flag.String("config", "", "a cuddled configuration file listing the actuators to simulate")
+
+	// parse flags
+	flag.Parse()
+
+	// print help
+	if *help {
+		flag.Usage()
+		os.Exit(0)
+	}
+
+	// do not accept arguments
+	if flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	// load actuators
+	if *configname != "" {
+		config, err := cuddle.LoadConfig(*configname)
+		if err != nil {
+			e.Fatalln(err)
+		}
+		if err := config.Register(); err != nil {
+			e.Fatalln(err)
+		}
+	}
+
+	// create pseudo-terminal
+	pty, err := cuddle.OpenPty()
+	if err != nil {
+		e.Fatalln(err)
+	}
+	defer pty.Close()
+
+	// hold the slave open so that the port stays in raw mode and reads do
+	// not fail between clients
+	slave, err := pty.OpenSlave()
+	if err != nil {
+		e.Fatalln(err)
+	}
+	defer slave.Close()
+
+	name := pty.SlaveName()
+	if *link != "" {
+		os.Remove(*link)
+		if err := os.Symlink(name, *link); err != nil {
+			e.Fatalln(err)
+		}
+		defer os.Remove(*link)
+		name = *link
+	}
+	l.Println("Listening on", name)
+
+	// run simulator
+	s := sim.New()
+	s.Debug = *debug
+	if err := s.Serve(pty); err != nil {
+		e.Fatalln(err)
+	}
+}
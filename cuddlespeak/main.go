@@ -1,33 +1,43 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"encoding"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path"
+	"strings"
+	"time"
 
 	"../cuddle"
 	"../msgtype"
+	"../trajectory"
 )
 
 var debug = flag.Bool("debug", false, "print debug messages")
 var n = flag.Bool("n", false, "parse arguments, but don't send command")
+var timeout = flag.Duration("timeout", time.Second, "time to wait for a reply")
+var units = flag.String("units", "", "units of positions: raw, deg, rad or norm; defaults to those of the actuator")
+var speed = flag.Float64("speed", 1, "the speed at which to replay a session, relative to the original")
+var format = flag.String("format", "human", "the monitor output format: human, json or pcap")
+var capture = flag.String("capture", "", "a capture file for monitor to read instead of the serial port, as raw bytes or pcap")
 
 func main() {
 	// define actuator flags
 	help := flag.Bool("help", false, "print help")
-	ribs := flag.Bool("ribs", false, "send command to ribs actuator")
-	purr := flag.Bool("purr", false, "send command to purr actuator")
-	spine := flag.Bool("spine", false, "send command to spine actuator")
-	headx := flag.Bool("headx", false, "send command to head yaw actuator")
-	heady := flag.Bool("heady", false, "send command to head pitch actuator")
+	actuator := flag.String("a", "", "the name of the actuator to which to send the command")
+	boards := make(map[string]*bool)
+	for _, a := range msgtype.DefaultActuators() {
+		boards[a.Name] = flag.Bool(a.Name, false, "send command to "+a.Description)
+	}
 
 	portname := flag.String("port", "/dev/ttyUSB0", "the serial port name")
+	configname := flag.String("config", "", "a cuddled configuration file listing the actuators")
+	limitsname := flag.String("limits", "", "a JSON file of actuator limits")
 
 	// parse flags
 	flag.Usage = usage
@@ -41,10 +51,58 @@ func main() {
 		os.Exit(0)
 	}
 
+	// load actuators
+	if *configname != "" {
+		if err := loadConfig(*configname); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	// list actuators
+	if args[0] == "list" {
+		if len(args) != 1 {
+			fatalUsage()
+		}
+		list()
+		return
+	}
+
+	// load limits
+	if *limitsname != "" {
+		if err := loadLimits(*limitsname); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	// find actuator
+	var addr msgtype.RemoteAddress
+	for _, a := range msgtype.DefaultActuators() {
+		if *actuator == "" && *boards[a.Name] {
+			*actuator = a.Name
+		}
+	}
+	// watch the line, for every actuator unless one is given
+	if args[0] == "monitor" {
+		if len(args) != 1 {
+			fatalUsage()
+		}
+		monitor(*portname, *capture, *actuator)
+		return
+	}
+
+	if args[0] != "estop" && args[0] != "replay" {
+		if *actuator == "" {
+			fatalUsage()
+		} else if err := addr.UnmarshalText([]byte(*actuator)); err != nil {
+			log.Fatalf("Error: unknown actuator %q", *actuator)
+		}
+	}
+
 	// open serial port
 	var port io.ReadWriteCloser
 	if !*n {
-		port, err := cuddle.OpenPort(*portname)
+		var err error
+		port, err = cuddle.OpenPort(*portname)
 		if err != nil {
 			log.Fatalln(err)
 		}
@@ -52,19 +110,28 @@ func main() {
 		log.Println("Connected to", *portname)
 	}
 
-	// run command
-	switch true {
-	case *ribs:
-		runcmd(port, msgtype.RibsAddress, args)
-	case *purr:
-		runcmd(port, msgtype.PurrAddress, args)
-	case *spine:
-		runcmd(port, msgtype.SpineAddress, args)
-	case *headx:
-		runcmd(port, msgtype.HeadXAddress, args)
-	case *heady:
-		runcmd(port, msgtype.HeadYAddress, args)
+	// replay a recorded session
+	if args[0] == "replay" {
+		if len(args) != 2 {
+			fatalUsage()
+		}
+		replay(port, args[1])
+		return
+	}
+
+	// commands for every actuator
+	if args[0] == "estop" {
+		if len(args) != 1 {
+			fatalUsage()
+		}
+		for _, addr := range msgtype.Addresses() {
+			sendcmd(port, &msgtype.Sleep{addr})
+		}
+		return
 	}
+
+	// run command
+	runcmd(port, addr, args)
 }
 
 func runcmd(conn io.ReadWriter, addr msgtype.RemoteAddress, args []string) {
@@ -109,34 +176,47 @@ func runcmd(conn io.ReadWriter, addr msgtype.RemoteAddress, args []string) {
 			log.Fatalln("Error: delay and loop must be positive")
 		}
 
-		if len(args)%2 != 0 {
-			log.Fatalln("Error: duration and setpoint must be given in pairs")
+		setpoints := parseSetpoints(addr, args)
+		m := &msgtype.Setpoint{addr, uint16(delay), uint16(loop), setpoints}
+
+		sendprogram(conn, m)
+
+	case "smooth":
+		if len(args) < 3 {
+			fatalUsage()
 		}
 
-		setpoints := make([]msgtype.SetpointValue, len(args)/2)
-		for i := 0; i < len(args); i += 2 {
-			durationS, setpointS := args[i], args[i+1]
+		intervalS, args := args[0], args[1:]
 
-			var duration, setpoint int
-			if durationS == "forever" {
-				duration = 0xffff
-			} else {
-				fmt.Fscanf(bytes.NewBufferString(durationS), "%d", &duration)
-			}
-			fmt.Fscanf(bytes.NewBufferString(setpointS), "%d", &setpoint)
+		var interval int
+		fmt.Fscanf(bytes.NewBufferString(intervalS), "%d", &interval)
 
-			if duration < 0 || setpoint < 0 {
-				log.Fatalln("Error: duration and setpoint must be positive")
-			}
+		if interval < 0 || interval > 0xffff {
+			log.Fatalln("Error: interval must be between 0 and 65535")
+		}
 
-			j := i / 2
+		setpoints := parseSetpoints(addr, args)
+		if len(setpoints) > msgtype.MaxSetpoints {
+			log.Fatalf("Error: at most %d setpoints may be given", msgtype.MaxSetpoints)
+		}
+
+		sendcmd(conn, &msgtype.Smooth{addr, uint16(interval), setpoints})
+
+	case "trajectory":
+		if len(args) == 0 {
+			fatalUsage()
+		}
 
-			setpoints[j].Duration = uint16(duration)
-			setpoints[j].Setpoint = uint16(setpoint)
+		m, err := parseTrajectory(addr, args)
+		if err != nil {
+			log.Fatalln("Error:", err)
 		}
 
-		sendcmd(conn, &msgtype.Setpoint{addr,
-			uint16(delay), uint16(loop), setpoints})
+		if sp, ok := m.(*msgtype.Setpoint); ok {
+			sendprogram(conn, sp)
+		} else {
+			sendcmd(conn, m)
+		}
 
 	case "ping":
 		if len(args) != 0 {
@@ -146,10 +226,11 @@ func runcmd(conn io.ReadWriter, addr msgtype.RemoteAddress, args []string) {
 		sendcmd(conn, &msgtype.Ping{addr})
 
 		if !*n {
-			buf := make([]byte, 1)
-			conn.Read(buf)
-			os.Stdout.Write(buf)
-			os.Stdout.WriteString("\n")
+			readreply(conn, func(m msgtype.Message) bool {
+				pong, ok := m.(*msgtype.Pong)
+				return ok && pong.Addr == addr
+			})
+			fmt.Println("pong")
 		}
 
 	case "test":
@@ -167,12 +248,15 @@ func runcmd(conn io.ReadWriter, addr msgtype.RemoteAddress, args []string) {
 		sendcmd(conn, &msgtype.Value{addr})
 
 		if !*n {
-			if line, _, err := bufio.NewReader(conn).ReadLine(); err != nil {
-				log.Fatalln(err)
-			} else {
-				os.Stdout.Write(line)
-				os.Stdout.WriteString("\n")
+			m := readreply(conn, func(m msgtype.Message) bool {
+				reading, ok := m.(*msgtype.Reading)
+				return ok && reading.Addr == addr
+			})
+			v, err := msgtype.DecodePosition(addr, m.(*msgtype.Reading).Position, *units)
+			if err != nil {
+				log.Fatalln("Error:", err)
 			}
+			fmt.Println(v)
 		}
 
 	default:
@@ -184,7 +268,88 @@ func runcmd(conn io.ReadWriter, addr msgtype.RemoteAddress, args []string) {
 	}
 }
 
+func replay(conn io.Writer, name string) {
+	f, err := os.Open(name)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	entries, err := cuddle.ReadSession(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("Error: %s: %s", name, err)
+	}
+
+	p, err := cuddle.NewReplayer(entries, *speed, func(m encoding.BinaryMarshaler) error {
+		sendcmd(conn, m)
+		if *debug {
+			log.Printf("sent %s message to %q", msgtype.NameOf(m), rune(msgtype.AddressOf(m)))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalln("Error:", err)
+	}
+	log.Printf("Replaying %d commands over %v", p.Len(), p.Duration())
+	p.Run(nil)
+}
+
+func loadConfig(name string) error {
+	config, err := cuddle.LoadConfig(name)
+	if err != nil {
+		return err
+	}
+	return config.Register()
+}
+
+func loadLimits(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return cuddle.LoadLimits(f)
+}
+
+func list() {
+	for _, a := range msgtype.Actuators() {
+		caps := "all"
+		if a.Capabilities != nil {
+			caps = strings.Join(a.Capabilities, ",")
+		}
+		units := a.Units
+		if units == "" {
+			units = "-"
+		}
+		fmt.Printf("%-10s %c  %5d..%-5d  %-8s %-40s %s\n",
+			a.Name, rune(a.Addr), a.Range[0], a.Range[1], units, caps, a.Description)
+	}
+}
+
 func sendcmd(conn io.Writer, m encoding.BinaryMarshaler) {
+	if err := cuddle.CheckLimits(m); err != nil {
+		log.Fatalln(err)
+	}
+	writecmd(conn, m)
+}
+
+// Send a setpoint program of any length, checking the limits on the whole
+// program and sending long programs in chunks until they are done.
+func sendprogram(conn io.Writer, m *msgtype.Setpoint) {
+	if err := cuddle.CheckLimits(m); err != nil {
+		log.Fatalln(err)
+	}
+	if len(m.Setpoints) <= msgtype.MaxSetpoints {
+		writecmd(conn, m)
+		return
+	}
+	cuddle.NewUploader(m, func(m encoding.BinaryMarshaler) error {
+		writecmd(conn, m)
+		return nil
+	}).Run(nil)
+}
+
+// Send a message without checking the limits.
+func writecmd(conn io.Writer, m encoding.BinaryMarshaler) {
 	if bs, err := m.MarshalBinary(); err != nil {
 		log.Fatalln(err)
 	} else if !*n {
@@ -196,6 +361,142 @@ func sendcmd(conn io.Writer, m encoding.BinaryMarshaler) {
 	}
 }
 
+func readreply(conn io.Reader, match func(msgtype.Message) bool) msgtype.Message {
+	result := make(chan msgtype.Message, 1)
+	r := msgtype.NewReader(conn)
+
+	go func() {
+		for {
+			m, err := r.ReadMessage()
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				log.Fatalln(err)
+			} else if err != nil {
+				log.Println("Error:", err)
+			} else if match(m) {
+				result <- m
+				return
+			} else if *debug {
+				log.Printf("ignored message %+v", m)
+			}
+		}
+	}()
+
+	select {
+	case m := <-result:
+		if *debug {
+			log.Printf("read message %+v %+v", m, r.Stats())
+		}
+		return m
+	case <-time.After(*timeout):
+		log.Fatalln("Error: timed out waiting for reply")
+	}
+
+	return nil
+}
+
+// Parse duration and setpoint pairs in the units given by -units.
+func parseSetpoints(addr msgtype.RemoteAddress, args []string) []msgtype.SetpointValue {
+	if len(args)%2 != 0 {
+		log.Fatalln("Error: duration and setpoint must be given in pairs")
+	}
+
+	pairs := make([]float64, len(args))
+	for i, arg := range args {
+		if i%2 == 0 && arg == "forever" {
+			pairs[i] = 0xffff
+		} else if _, err := fmt.Sscanf(arg, "%g", &pairs[i]); err != nil {
+			log.Fatalf("Error: invalid number %q", arg)
+		}
+	}
+
+	setpoints, err := msgtype.EncodeSetpoints(addr, pairs, *units)
+	if err != nil {
+		log.Fatalln("Error:", err)
+	}
+	return setpoints
+}
+
+// Parse key=value arguments describing a trajectory, with the fields of
+// the trajectory in the HTTP API, and sample it in the units given by
+// -units. Keyframes are given as time:position,time:position...
+func parseTrajectory(addr msgtype.RemoteAddress, args []string) (encoding.BinaryMarshaler, error) {
+	fields := make(map[string]interface{})
+	var resolution, delay uint16
+	var smooth bool
+
+	for _, arg := range args {
+		i := strings.Index(arg, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("expected key=value, got %q", arg)
+		}
+		key, value := arg[:i], arg[i+1:]
+
+		var err error
+		switch key {
+		case "type", "ease":
+			fields[key] = value
+		case "resolution":
+			_, err = fmt.Sscanf(value, "%d", &resolution)
+		case "delay":
+			_, err = fmt.Sscanf(value, "%d", &delay)
+		case "smooth":
+			smooth = value == "true"
+		case "keyframes":
+			var keyframes [][2]float64
+			for _, k := range strings.Split(value, ",") {
+				var kf [2]float64
+				if _, err = fmt.Sscanf(k, "%g:%g", &kf[0], &kf[1]); err != nil {
+					break
+				}
+				keyframes = append(keyframes, kf)
+			}
+			fields[key] = keyframes
+		default:
+			var f float64
+			_, err = fmt.Sscanf(value, "%g", &f)
+			fields[key] = f
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q", key, value)
+		}
+	}
+
+	// decode through JSON to catch unknown keys
+	var spec trajectory.Spec
+	buf, _ := json.Marshal(fields)
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		return nil, err
+	}
+
+	tr, err := spec.Trajectory()
+	if err != nil {
+		return nil, err
+	}
+	values, err := trajectory.Sample(tr, time.Duration(resolution)*time.Millisecond, addr, *units)
+	if err != nil {
+		return nil, err
+	}
+
+	if smooth {
+		if spec.Forever() {
+			return nil, fmt.Errorf("smooth motion cannot repeat forever")
+		} else if delay != 0 {
+			return nil, fmt.Errorf("smooth motion cannot be delayed")
+		} else if len(values) > msgtype.MaxSetpoints {
+			return nil, fmt.Errorf("at most %d setpoints may be given", msgtype.MaxSetpoints)
+		}
+		return &msgtype.Smooth{addr, 0, values}, nil
+	}
+
+	loop := uint16(0)
+	if spec.Forever() {
+		loop = msgtype.LOOP_INFINITE
+	}
+	return &msgtype.Setpoint{addr, delay, loop, values}, nil
+}
+
 var header = `Cuddlespeak is a tool for testing the Cuddlebot actuators.
 
 Usage:
@@ -212,9 +513,21 @@ The commands are:
 
     setpid      set the PID coefficients
     setpoint    send setpoints
+    smooth      move smoothly through setpoints
+    trajectory  follow a ramp, wave or spline
     ping        send a ping
+    estop       put every actuator to sleep; no actuator flag is needed
+    list        list the actuators; no actuator flag is needed
+    monitor     print every frame on the line, or in the file given
+                by -capture, in the format given by -format; frames
+                with bad checksums are printed too, and only frames
+                for the actuator are printed if one is given
+    replay      re-send the commands in a session file recorded by
+                cuddled, at the speed given by -speed; no actuator
+                flag is needed
     test        send test command
-    value       read motor position
+    value       read motor position in the units given by -units,
+                or in (1 / 2^16) increments of a circle by default
 
 The setpid command accepts these arguments:
 
@@ -229,23 +542,79 @@ The setpoint command accepts these arguments:
                 setpoints or "forever" to loop indefinitely
     [duration setpoint]+
                 one or more setpoints consisting of groups of two
-                uints in order: duration setpoint; with duration in
-                milliseconds and setpoint in (1 / 2^16) increments of
-                a circle
+                numbers in order: duration setpoint; with duration in
+                milliseconds or "forever", and setpoint in the units
+                given by -units, or in (1 / 2^16) increments of a
+                circle by default; programs of more than 254
+                setpoints are sent in chunks until they finish
+
+The smooth command accepts these arguments:
+
+    interval    uint: time between updates of the target in
+                milliseconds, or 0 to update continuously
+    [duration setpoint]+
+                one or more setpoints, up to 254, as for setpoint;
+                the target moves to each in turn over its duration
+
+The trajectory command accepts key=value arguments:
+
+    type        linear, minjerk, sine, breathe or spline
+    from, to, duration
+                for linear and minjerk, with duration in ms
+    ease        for linear: linear, in-quad, out-quad, in-out-quad,
+                in-cubic, out-cubic, in-out-cubic, in-out-sine
+                or minjerk
+    center, amplitude, cycles
+                for sine and breathe, repeating forever if cycles
+                is 0 or not given
+    period      for sine, in ms
+    rate        for breathe, in breaths per minute
+    keyframes   for spline, as time:position,time:position... with
+                times in ms starting at 0
+    resolution  time between samples in ms, 50 by default
+    delay       time before starting in ms
+    smooth      true to send smooth motion instead of setpoints
+
+Positions given in deg or rad are measured from the zero offset of the
+actuator in its configured direction; norm gives 0..1 across its range.
+
+The actuator is chosen with -a name, or with the shorthand flags for the
+built-in actuators. Other actuators are listed in the file given with
+-config, as for cuddled.
 
 Examples:
 
     $ %s -ribs setpid 40.4 1.0 -1.0
 
+    $ %s -config cuddled.toml -a tail setpoint 0 0 500 32768
+
+    $ %s -units deg -heady setpoint 0 0 1000 -15 1000 15
+
     $ %s -ribs setpoint 0 forever 1000 26075 1000 0
 
+    $ %s -units deg -spine smooth 10 500 20 1500 -20 500 0
+
+    $ %s -units deg -ribs trajectory type=breathe amplitude=20 rate=12
+
     $ %s -ribs ping
+    pong
+
+    $ %s estop
+
+    $ %s -speed 2 replay session.jsonl
+
+    $ %s monitor
+    12:30:01.204511  r ribs   setpoint ok  delay=0 loop=0 [500:1000]
+    12:30:01.250342  r ribs   value    ok
+    12:30:01.251020  r ribs   reading  ok  position=612
+
+    $ %s -format pcap monitor > line.pcap
 
     $ %s -ribs test
     ... test results ...
 
     $ %s -ribs value
-    0.1
+    26075
 
 `
 
@@ -257,7 +626,7 @@ func usage() {
 		fmt.Fprintf(os.Stderr, "    -%-10s %s\n", f.Name, f.Usage)
 	})
 
-	fmt.Fprintf(os.Stderr, footer, name, name, name, name, name)
+	fmt.Fprintf(os.Stderr, footer, name, name, name, name, name, name, name, name, name, name, name, name, name)
 }
 
 func fatalUsage() {
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"../cuddle"
+	"../msgtype"
+)
+
+// Monitor output formats.
+const (
+	formatHuman = "human"
+	formatJSON  = "json"
+	formatPcap  = "pcap"
+)
+
+// Pcap file header fields. Frames are written with the first user link
+// type, one frame to a packet.
+const (
+	pcapMagic        = 0xa1b2c3d4 // microsecond timestamps
+	pcapMagicNano    = 0xa1b23c4d // nanosecond timestamps
+	pcapLinkTypeUser = 147
+	pcapSnapLen      = 0xffff
+)
+
+// A frame seen on the line.
+type monitorFrame struct {
+	Time     time.Time       `json:"time"`
+	Addr     string          `json:"addr"` // board address character
+	Actuator string          `json:"actuator,omitempty"`
+	Type     string          `json:"type"`
+	Checksum string          `json:"checksum"` // ok or bad
+	Frame    string          `json:"frame"`    // in hex
+	Message  json.RawMessage `json:"message,omitempty"`
+	Error    string          `json:"error,omitempty"` // decoding error
+
+	frame msgtype.Frame
+	m     msgtype.Message
+}
+
+// A source of frames: a serial port or a capture file.
+type frameSource interface {
+	// Get the next frame, the time it was seen and whether its checksum is
+	// valid.
+	next() (msgtype.Frame, time.Time, bool, error)
+}
+
+// Frames read from a byte stream, timed as they arrive.
+type streamSource struct {
+	r *msgtype.Reader
+}
+
+// Frames read from a pcap file, one to a packet.
+type pcapSource struct {
+	r     io.Reader
+	order binary.ByteOrder
+	nano  bool
+}
+
+// Print every frame on the line, or in a capture file, passively. Frames
+// with bad checksums are printed too. Only frames to or from the named
+// actuator are printed, if one is given.
+func monitor(portname, capturename, actuator string) {
+	var filter msgtype.RemoteAddress
+	if actuator != "" {
+		if err := filter.UnmarshalText([]byte(actuator)); err != nil {
+			log.Fatalf("Error: unknown actuator %q", actuator)
+		}
+	}
+
+	var in io.Reader
+	if capturename != "" {
+		f, err := os.Open(capturename)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		in = f
+	} else {
+		port, err := cuddle.OpenPort(portname)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer port.Close()
+		log.Println("Monitoring", portname)
+		in = port
+	}
+
+	src, err := newFrameSource(in)
+	if err != nil {
+		log.Fatalln("Error:", err)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	var write func(*monitorFrame) error
+	switch *format {
+	case formatHuman:
+		write = func(f *monitorFrame) error {
+			_, err := fmt.Fprintln(out, f.String())
+			return err
+		}
+	case formatJSON:
+		enc := json.NewEncoder(out)
+		write = func(f *monitorFrame) error {
+			return enc.Encode(f)
+		}
+	case formatPcap:
+		if err := writePcapHeader(out); err != nil {
+			log.Fatalln(err)
+		}
+		write = func(f *monitorFrame) error {
+			return writePcapRecord(out, f.Time, f.frame)
+		}
+	default:
+		log.Fatalf("Error: unknown format %q", *format)
+	}
+
+	var frames, bad int
+	for {
+		frame, at, ok, err := src.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			out.Flush()
+			log.Fatalln(err)
+		}
+		if filter != msgtype.InvalidAddress && frame.Addr() != filter {
+			continue
+		}
+
+		frames++
+		if !ok {
+			bad++
+		}
+		if err := write(newMonitorFrame(frame, at, ok)); err != nil {
+			log.Fatalln(err)
+		}
+		// show frames as they arrive
+		if capturename == "" {
+			out.Flush()
+		}
+	}
+	log.Printf("%d frames, %d with bad checksums", frames, bad)
+}
+
+// Read frames from a pcap file, or from a stream of raw bytes otherwise.
+func newFrameSource(in io.Reader) (frameSource, error) {
+	br := bufio.NewReader(in)
+	header, err := br.Peek(24)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(header) == 24 {
+		for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			switch order.Uint32(header) {
+			case pcapMagic, pcapMagicNano:
+				if link := order.Uint32(header[20:]); link != pcapLinkTypeUser {
+					return nil, fmt.Errorf("unexpected pcap link type %d", link)
+				}
+				br.Discard(24)
+				return &pcapSource{br, order, order.Uint32(header) == pcapMagicNano}, nil
+			}
+		}
+	}
+	return &streamSource{msgtype.NewReader(br)}, nil
+}
+
+func (s *streamSource) next() (msgtype.Frame, time.Time, bool, error) {
+	frame, ok, err := s.r.ReadAnyFrame()
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return frame, time.Now(), ok, err
+}
+
+func (s *pcapSource) next() (msgtype.Frame, time.Time, bool, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(s.r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return nil, time.Time{}, false, err
+	}
+	sec := int64(s.order.Uint32(header[0:]))
+	frac := int64(s.order.Uint32(header[4:]))
+	if !s.nano {
+		frac *= 1000
+	}
+	n := s.order.Uint32(header[8:])
+	if n > pcapSnapLen {
+		return nil, time.Time{}, false, fmt.Errorf("pcap packet of %d bytes is too long", n)
+	}
+
+	frame := make(msgtype.Frame, n)
+	if _, err := io.ReadFull(s.r, frame); err != nil {
+		return nil, time.Time{}, false, err
+	}
+	// packets too short or long to be frames are reported as bad
+	ok := n >= 6 && n <= 6+msgtype.MaxDataSize && frame.ValidChecksum()
+	return frame, time.Unix(sec, frac), ok, nil
+}
+
+func writePcapHeader(w io.Writer) error {
+	var header [24]byte
+	binary.LittleEndian.PutUint32(header[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(header[4:], 2) // version 2.4
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(header[20:], pcapLinkTypeUser)
+	_, err := w.Write(header[:])
+	return err
+}
+
+func writePcapRecord(w io.Writer, at time.Time, frame msgtype.Frame) error {
+	var header [16]byte
+	binary.LittleEndian.PutUint32(header[0:], uint32(at.Unix()))
+	binary.LittleEndian.PutUint32(header[4:], uint32(at.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(header[8:], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(frame)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(frame)
+	return err
+}
+
+func newMonitorFrame(frame msgtype.Frame, at time.Time, ok bool) *monitorFrame {
+	f := &monitorFrame{
+		Time:     at,
+		Type:     frame.TypeName(),
+		Checksum: "ok",
+		Frame:    hex.EncodeToString(frame),
+		frame:    frame,
+	}
+	if addr := frame.Addr(); addr != msgtype.InvalidAddress {
+		f.Addr = string(rune(addr))
+	}
+	if info, found := msgtype.LookupAddress(frame.Addr()); found {
+		f.Actuator = info.Name
+	}
+	if !ok {
+		f.Checksum = "bad"
+		return f
+	}
+
+	m, err := frame.Message()
+	if err != nil {
+		f.Error = err.Error()
+		return f
+	}
+	f.m = m
+	f.Message, _ = json.Marshal(m)
+	return f
+}
+
+// Format the frame on one line: the time, address, actuator name, type and
+// checksum status, then the decoded message, or the frame in hex if it
+// could not be decoded.
+func (f *monitorFrame) String() string {
+	addr, actuator := f.Addr, f.Actuator
+	if addr == "" {
+		addr = "?"
+	}
+	if actuator == "" {
+		actuator = "?"
+	}
+	line := fmt.Sprintf("%s  %s %-6s %-8s %-4s",
+		f.Time.Format("15:04:05.000000"), addr, actuator, f.Type, f.Checksum)
+	if f.m == nil {
+		if f.Error != "" {
+			line += f.Error + " "
+		}
+		return strings.TrimRight(line+f.Frame, " ")
+	}
+	return strings.TrimRight(line+describe(f.m), " ")
+}
+
+// Describe the contents of a message.
+func describe(m msgtype.Message) string {
+	switch m := m.(type) {
+	case *msgtype.SetPID:
+		return fmt.Sprintf("kp=%g ki=%g kd=%g", m.Kp, m.Ki, m.Kd)
+	case *msgtype.Setpoint:
+		loop := fmt.Sprint(m.Loop)
+		if m.Loop == msgtype.LOOP_INFINITE {
+			loop = "forever"
+		}
+		return fmt.Sprintf("delay=%d loop=%s %s", m.Delay, loop, describeSetpoints(m.Setpoints))
+	case *msgtype.Smooth:
+		return fmt.Sprintf("interval=%d %s", m.Time, describeSetpoints(m.Setpoint))
+	case *msgtype.Reading:
+		return fmt.Sprintf("position=%d", m.Position)
+	}
+	return ""
+}
+
+// Describe setpoints as duration:setpoint pairs, giving the first few only.
+func describeSetpoints(values []msgtype.SetpointValue) string {
+	const most = 6
+
+	parts := make([]string, 0, most+1)
+	for i, sp := range values {
+		if i == most {
+			parts = append(parts, fmt.Sprintf("... %d more", len(values)-most))
+			break
+		}
+		d := fmt.Sprint(sp.Duration)
+		if sp.Duration == msgtype.LOOP_INFINITE {
+			d = "forever"
+		}
+		parts = append(parts, d+":"+fmt.Sprint(sp.Setpoint))
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
@@ -2,9 +2,11 @@ package msgtype
 
 import (
 	"bytes"
+	"encoding"
 	"encoding/binary"
 	"errors"
 	"io"
+	"time"
 
 	"github.com/mikepb/go-crc16"
 )
@@ -42,8 +44,18 @@ const (
 // Loop setpoints forever.
 const LOOP_INFINITE uint16 = 0xffff
 
+// Most setpoints in one setpoint or smooth message, being (1024-6)/4 for
+// 1024 byte max data.
+const MaxSetpoints = 254
+
 type RemoteAddress uint8
 
+// Message is implemented by every message type.
+type Message interface {
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+}
+
 // Data for simple message types.
 type simpleType struct {
 	Addr RemoteAddress `json:"addr"`
@@ -52,6 +64,9 @@ type simpleType struct {
 // Ping message type.
 type Ping simpleType
 
+// Pong message type, sent in response to a ping.
+type Pong simpleType
+
 // SetPID message type.
 type SetPID struct {
 	Addr RemoteAddress `json:"addr"`
@@ -74,6 +89,8 @@ type SetpointValue struct {
 	Setpoint uint16 `json:"setpoint"` // offset 0x02, setpoint
 }
 
+// Smooth message type, moving through each setpoint in turn over its
+// duration, updating the target every Time ms.
 type Smooth struct {
 	Addr	 RemoteAddress	`json:"addr"`
 	Time	 uint16		`json:"time"`
@@ -89,57 +106,134 @@ type Test simpleType
 // Value message type.
 type Value simpleType
 
+// Reading message type, sent in response to a value message.
+type Reading struct {
+	Addr     RemoteAddress `json:"addr"`
+	Position uint16        `json:"position"` // position in (1 / 2^16) turns
+}
+
 // Invalid message error.
 var InvalidAddressError = errors.New("Invalid address")
 
 // Invalid message error.
 var InvalidMessageError = errors.New("Invalid message")
 
-// Serialize address to text.
+// Invalid message type error.
+var InvalidTypeError = errors.New("Invalid message type")
+
+// Invalid message length error.
+var InvalidLengthError = errors.New("Invalid message length")
+
+// Invalid checksum error.
+var InvalidChecksumError = errors.New("Invalid checksum")
+
+// Serialize address to the name of the registered actuator.
 func (a *RemoteAddress) MarshalText() ([]byte, error) {
-	var s string
-
-	switch *a {
-	case RibsAddress:
-		s = RibsAddressString
-	case PurrAddress:
-		s = PurrAddressString
-	case SpineAddress:
-		s = SpineAddressString
-	case HeadXAddress:
-		s = HeadXAddressString
-	case HeadYAddress:
-		s = HeadYAddressString
-	default:
-		return nil, InvalidAddressError
+	if info, ok := LookupAddress(*a); ok {
+		return []byte(info.Name), nil
 	}
-
-	return bytes.NewBufferString(s).Bytes(), nil
+	return nil, InvalidAddressError
 }
 
-// Deserialize address from text.
+// Deserialize address from the name of a registered actuator.
 func (a *RemoteAddress) UnmarshalText(text []byte) error {
-	s, err := bytes.NewBuffer(text).ReadString(0)
-	if err != io.EOF {
-		return err
+	if info, ok := LookupName(string(text)); ok {
+		*a = info.Addr
+		return nil
+	}
+	return InvalidAddressError
+}
+
+// Get the address of a message of any type.
+func AddressOf(m encoding.BinaryMarshaler) RemoteAddress {
+	switch m := m.(type) {
+	case *Ping:
+		return m.Addr
+	case *Pong:
+		return m.Addr
+	case *SetPID:
+		return m.Addr
+	case *Setpoint:
+		return m.Addr
+	case *Smooth:
+		return m.Addr
+	case *Sleep:
+		return m.Addr
+	case *Test:
+		return m.Addr
+	case *Value:
+		return m.Addr
+	case *Reading:
+		return m.Addr
+	}
+	return InvalidAddress
+}
+
+// Get the name of the type of a message, as used for capabilities, with
+// "pong" and "reading" for the replies.
+func NameOf(m encoding.BinaryMarshaler) string {
+	switch m.(type) {
+	case *Ping:
+		return CanPing
+	case *Pong:
+		return "pong"
+	case *SetPID:
+		return CanSetPID
+	case *Setpoint:
+		return CanSetpoint
+	case *Smooth:
+		return CanSmooth
+	case *Sleep:
+		return CanSleep
+	case *Test:
+		return CanTest
+	case *Value:
+		return CanValue
+	case *Reading:
+		return "reading"
+	}
+	return ""
+}
+
+// Decode a message of any type.
+func Unmarshal(data []byte) (Message, error) {
+	if len(data) < 6 {
+		return nil, InvalidLengthError
 	}
 
-	switch s {
-	case RibsAddressString:
-		*a = RibsAddress
-	case PurrAddressString:
-		*a = PurrAddress
-	case SpineAddressString:
-		*a = SpineAddress
-	case HeadXAddressString:
-		*a = HeadXAddress
-	case HeadYAddressString:
-		*a = HeadYAddress
+	var m Message
+
+	switch data[1] {
+	case kPing:
+		m = &Ping{}
+	case kPong:
+		m = &Pong{}
+	case kSetPID:
+		m = &SetPID{}
+	case kSetpoint:
+		m = &Setpoint{}
+	case kSmooth:
+		m = &Smooth{}
+	case kSleep:
+		m = &Sleep{}
+	case kTest:
+		m = &Test{}
+	case kValue:
+		// readings share the value message type
+		if binary.LittleEndian.Uint16(data[2:4]) == 2 {
+			m = &Reading{}
+		} else {
+			m = &Value{}
+		}
 	default:
-		return InvalidAddressError
+		return nil, InvalidTypeError
 	}
 
-	return nil
+	if err := m.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+
+	return m, nil
 }
 
 // Encode ping message.
@@ -147,6 +241,21 @@ func (m *Ping) MarshalBinary() (data []byte, err error) {
 	return marshalBinary(m.Addr, kPing)
 }
 
+// Decode ping message.
+func (m *Ping) UnmarshalBinary(data []byte) error {
+	return unmarshalSimple(data, kPing, &m.Addr)
+}
+
+// Encode pong message.
+func (m *Pong) MarshalBinary() (data []byte, err error) {
+	return marshalBinary(m.Addr, kPong)
+}
+
+// Decode pong message.
+func (m *Pong) UnmarshalBinary(data []byte) error {
+	return unmarshalSimple(data, kPong, &m.Addr)
+}
+
 // Encode PID message.
 func (m *SetPID) MarshalBinary() (data []byte, err error) {
 	var b bytes.Buffer
@@ -170,12 +279,30 @@ func (m *SetPID) MarshalBinary() (data []byte, err error) {
 	return b.Bytes(), nil
 }
 
+// Decode PID message.
+func (m *SetPID) UnmarshalBinary(data []byte) error {
+	addr, payload, err := unmarshalBinary(data, kSetPID)
+	if err != nil {
+		return err
+	}
+	if len(payload) != 12 {
+		return InvalidLengthError
+	}
+	// read data
+	var d [3]float32
+	if err := binary.Read(bytes.NewReader(payload), binary.LittleEndian, &d); err != nil {
+		return err
+	}
+	m.Addr = addr
+	m.Kp, m.Ki, m.Kd = d[0], d[1], d[2]
+	return nil
+}
+
 // Write set Setpoint message.
 func (m *Setpoint) MarshalBinary() (data []byte, err error) {
 	nsetpoints := len(m.Setpoints)
 
-	// (1024-6)/4 = 254 max setpoints for 1024 byte max data
-	if nsetpoints <= 0 || nsetpoints > 254 {
+	if nsetpoints <= 0 || nsetpoints > MaxSetpoints {
 		return nil, InvalidMessageError
 	}
 
@@ -209,8 +336,101 @@ func (m *Setpoint) MarshalBinary() (data []byte, err error) {
 	return b.Bytes(), nil
 }
 
+// Read set Setpoint message.
+func (m *Setpoint) UnmarshalBinary(data []byte) error {
+	addr, payload, err := unmarshalBinary(data, kSetpoint)
+	if err != nil {
+		return err
+	}
+	if len(payload) < 6 {
+		return InvalidLengthError
+	}
+	// read data
+	r := bytes.NewReader(payload)
+	var d [3]uint16
+	if err := binary.Read(r, binary.LittleEndian, &d); err != nil {
+		return err
+	}
+	nsetpoints := int(d[2])
+	if nsetpoints <= 0 || nsetpoints > MaxSetpoints {
+		return InvalidMessageError
+	}
+	if len(payload) != 6+4*nsetpoints {
+		return InvalidLengthError
+	}
+	// read setpoint data
+	setpoints := make([]SetpointValue, nsetpoints)
+	if err := binary.Read(r, binary.LittleEndian, setpoints); err != nil {
+		return err
+	}
+	m.Addr = addr
+	m.Delay = d[0]
+	m.Loop = d[1]
+	m.Setpoints = setpoints
+	return nil
+}
+
+// Get the running time of the setpoints, including the delay. Returns
+// false if the setpoints loop forever or a setpoint is held forever.
+func (m *Setpoint) Duration() (time.Duration, bool) {
+	var period time.Duration
+	for _, sp := range m.Setpoints {
+		if sp.Duration == LOOP_INFINITE {
+			return 0, false
+		}
+		period += milliseconds(sp.Duration)
+	}
+	if m.Loop == LOOP_INFINITE {
+		return 0, false
+	}
+	return milliseconds(m.Delay) + period*time.Duration(m.Loop+1), true
+}
+
+// Get the setpoint active at the given time after the message is received.
+// Returns false during the initial delay.
+func (m *Setpoint) ValueAt(t time.Duration) (uint16, bool) {
+	t -= milliseconds(m.Delay)
+	if t < 0 || len(m.Setpoints) == 0 {
+		return 0, false
+	}
+
+	last := m.Setpoints[len(m.Setpoints)-1].Setpoint
+
+	var period time.Duration
+	for _, sp := range m.Setpoints {
+		period += milliseconds(sp.Duration)
+	}
+	if period == 0 {
+		return last, true
+	}
+
+	// setpoints are played once, then repeated loop times
+	if m.Loop != LOOP_INFINITE && t >= period*time.Duration(m.Loop+1) {
+		return last, true
+	}
+	t %= period
+
+	for _, sp := range m.Setpoints {
+		if sp.Duration == LOOP_INFINITE {
+			return sp.Setpoint, true
+		}
+		d := milliseconds(sp.Duration)
+		if t < d {
+			return sp.Setpoint, true
+		}
+		t -= d
+	}
+
+	return last, true
+}
+
 // Write smooth motion message
 func (m *Smooth) MarshalBinary() (data []byte, err error) {
+	nsetpoints := len(m.Setpoint)
+	if nsetpoints <= 0 || nsetpoints > MaxSetpoints {
+		return nil, InvalidMessageError
+	}
+
 	var b bytes.Buffer
 	h := crc16.NewANSI()
 	ww := io.MultiWriter(&b, h)
@@ -219,7 +439,7 @@ func (m *Smooth) MarshalBinary() (data []byte, err error) {
 		return
 	}
 	// write size
-	size := uint16(6)
+	size := uint16(2 + 4*nsetpoints)
 	if err = binary.Write(ww, binary.LittleEndian, size); err != nil {
 		return
 	}
@@ -241,21 +461,101 @@ func (m *Smooth) MarshalBinary() (data []byte, err error) {
 	return b.Bytes(), nil
 }
 
+// Read smooth motion message.
+func (m *Smooth) UnmarshalBinary(data []byte) error {
+	addr, payload, err := unmarshalBinary(data, kSmooth)
+	if err != nil {
+		return err
+	}
+	if len(payload) < 6 || (len(payload)-2)%4 != 0 {
+		return InvalidLengthError
+	}
+	if (len(payload)-2)/4 > MaxSetpoints {
+		return InvalidMessageError
+	}
+	// read data
+	r := bytes.NewReader(payload)
+	var t uint16
+	if err := binary.Read(r, binary.LittleEndian, &t); err != nil {
+		return err
+	}
+	// read setpoint data
+	setpoint := make([]SetpointValue, (len(payload)-2)/4)
+	if err := binary.Read(r, binary.LittleEndian, setpoint); err != nil {
+		return err
+	}
+	m.Addr = addr
+	m.Time = t
+	m.Setpoint = setpoint
+	return nil
+}
+
 // Write sleep test message.
 func (m *Sleep) MarshalBinary() (data []byte, err error) {
 	return marshalBinary(m.Addr, kSleep)
 }
 
+// Read sleep test message.
+func (m *Sleep) UnmarshalBinary(data []byte) error {
+	return unmarshalSimple(data, kSleep, &m.Addr)
+}
+
 // Write set test message.
 func (m *Test) MarshalBinary() (data []byte, err error) {
 	return marshalBinary(m.Addr, kTest)
 }
 
+// Read set test message.
+func (m *Test) UnmarshalBinary(data []byte) error {
+	return unmarshalSimple(data, kTest, &m.Addr)
+}
+
 // Write request value message.
 func (m *Value) MarshalBinary() (data []byte, err error) {
 	return marshalBinary(m.Addr, kValue)
 }
 
+// Read request value message.
+func (m *Value) UnmarshalBinary(data []byte) error {
+	return unmarshalSimple(data, kValue, &m.Addr)
+}
+
+// Write position reading message.
+func (m *Reading) MarshalBinary() (data []byte, err error) {
+	var b bytes.Buffer
+	h := crc16.NewANSI()
+	ww := io.MultiWriter(&b, h)
+	// write header
+	if _, err = ww.Write([]uint8{uint8(m.Addr), kValue, 2, 0}); err != nil {
+		return
+	}
+	// write data
+	if err = binary.Write(ww, binary.LittleEndian, m.Position); err != nil {
+		return
+	}
+	// write checksum
+	sum := h.Sum16()
+	if err = binary.Write(ww, binary.LittleEndian, sum); err != nil {
+		return
+	}
+	// return data
+	return b.Bytes(), nil
+}
+
+// Read position reading message.
+func (m *Reading) UnmarshalBinary(data []byte) error {
+	addr, payload, err := unmarshalBinary(data, kValue)
+	if err != nil {
+		return err
+	}
+	if len(payload) != 2 {
+		return InvalidLengthError
+	}
+	m.Addr = addr
+	m.Position = binary.LittleEndian.Uint16(payload)
+	return nil
+}
+
 // Marshal simple message.
 func marshalBinary(addr RemoteAddress, msgtype uint8) (data []byte, err error) {
 	var b bytes.Buffer
@@ -272,3 +572,51 @@ func marshalBinary(addr RemoteAddress, msgtype uint8) (data []byte, err error) {
 	// return contents
 	return b.Bytes(), nil
 }
+
+// Unmarshal simple message.
+func unmarshalSimple(data []byte, msgtype uint8, addr *RemoteAddress) error {
+	a, payload, err := unmarshalBinary(data, msgtype)
+	if err != nil {
+		return err
+	}
+	if len(payload) != 0 {
+		return InvalidLengthError
+	}
+	*addr = a
+	return nil
+}
+
+// Unmarshal message header and checksum, returning the message data.
+func unmarshalBinary(data []byte, msgtype uint8) (addr RemoteAddress, payload []byte, err error) {
+	// header and checksum are 6 bytes
+	if len(data) < 6 {
+		return InvalidAddress, nil, InvalidLengthError
+	}
+	// check header
+	if data[1] != msgtype {
+		return InvalidAddress, nil, InvalidTypeError
+	}
+	size := int(binary.LittleEndian.Uint16(data[2:4]))
+	if len(data) != 6+size {
+		return InvalidAddress, nil, InvalidLengthError
+	}
+	// check checksum
+	if !validChecksum(data) {
+		return InvalidAddress, nil, InvalidChecksumError
+	}
+	// return contents
+	return RemoteAddress(data[0]), data[4 : 4+size], nil
+}
+
+// Check the trailing checksum of a complete message.
+func validChecksum(data []byte) bool {
+	n := len(data) - 2
+	h := crc16.NewANSI()
+	h.Write(data[:n])
+	return h.Sum16() == binary.LittleEndian.Uint16(data[n:])
+}
+
+// Convert a duration in milliseconds.
+func milliseconds(ms uint16) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
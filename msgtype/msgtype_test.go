@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"encoding"
 	"io"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestRemoteAddressMarshalText(t *testing.T) {
@@ -33,6 +35,10 @@ func TestPing(t *testing.T) {
 	testMarshalExpect(t, &Ping{'r'}, []byte{'r', '?', 0, 0, 6, 51})
 }
 
+func TestPong(t *testing.T) {
+	testMarshalExpect(t, &Pong{'r'}, []byte{'r', '.', 0, 0, 114, 207})
+}
+
 func TestSetPID(t *testing.T) {
 	testMarshalExpect(t, &SetPID{2, 1.0, 2.0, 3.0},
 		[]byte{2, 'c', 12, 0, 0, 0, 128, 63, 0, 0, 0, 64, 0, 0, 64, 64, 93, 23})
@@ -70,6 +76,62 @@ func TestSetpoint(t *testing.T) {
 	})
 }
 
+func TestSmooth(t *testing.T) {
+	// no setpoints
+	if _, err := (&Smooth{4, 20, nil}).MarshalBinary(); err == nil {
+		t.Fatal("Smooth did not return an error for empty set")
+	}
+	// too many setpoints
+	if _, err := (&Smooth{4, 20, make([]SetpointValue, MaxSetpoints+1)}).MarshalBinary(); err == nil {
+		t.Fatal("Smooth did not return an error for too many setpoints")
+	}
+	// one setpoint
+	testMarshalExpect(t, &Smooth{4, 20, []SetpointValue{
+		SetpointValue{Duration: 500, Setpoint: 1024},
+	}}, []byte{
+		4, 'h', 6, 0,
+		20, 0,
+		244, 1, 0, 4,
+		135, 73,
+	})
+	// two setpoints
+	testMarshalExpect(t, &Smooth{4, 20, []SetpointValue{
+		SetpointValue{Duration: 500, Setpoint: 1024},
+		SetpointValue{Duration: 250, Setpoint: 0},
+	}}, []byte{
+		4, 'h', 10, 0,
+		20, 0,
+		244, 1, 0, 4,
+		250, 0, 0, 0,
+		221, 244,
+	})
+}
+
+func TestSetpointValueAt(t *testing.T) {
+	m := &Setpoint{Delay: 100, Loop: 1, Setpoints: []SetpointValue{
+		SetpointValue{Duration: 50, Setpoint: 8},
+		SetpointValue{Duration: 150, Setpoint: 95},
+	}}
+	if d, ok := m.Duration(); !ok || d != 500*time.Millisecond {
+		t.Fatalf("Expected 500ms, got %v", d)
+	}
+	if _, ok := m.ValueAt(50 * time.Millisecond); ok {
+		t.Fatal("Setpoint active during delay")
+	}
+	for ms, expect := range map[int]uint16{100: 8, 160: 95, 320: 8, 360: 95, 1000: 95} {
+		if v, _ := m.ValueAt(time.Duration(ms) * time.Millisecond); v != expect {
+			t.Fatalf("Expected %d at %dms, got %d", expect, ms, v)
+		}
+	}
+	m.Loop = LOOP_INFINITE
+	if _, ok := m.Duration(); ok {
+		t.Fatal("Infinite setpoints have a duration")
+	}
+	if v, _ := m.ValueAt(1100 * time.Millisecond); v != 8 {
+		t.Fatalf("Expected 8 at 1100ms, got %d", v)
+	}
+}
+
 func TestSleep(t *testing.T) {
 	testMarshalExpect(t, &Sleep{9},
 		[]byte{9, 'z', 0, 0, 181, 68})
@@ -83,6 +145,86 @@ func TestRequestPosition(t *testing.T) {
 	testMarshalExpect(t, &Value{1}, []byte{1, 'v', 0, 0, 70, 158})
 }
 
+func TestReading(t *testing.T) {
+	testMarshalExpect(t, &Reading{1, 26075}, []byte{1, 'v', 2, 0, 219, 101, 17, 196})
+}
+
+func TestUnmarshalBinary(t *testing.T) {
+	testUnmarshalExpect(t, &Ping{'r'}, &Ping{})
+	testUnmarshalExpect(t, &SetPID{2, 1.0, 2.0, 3.0}, &SetPID{})
+	testUnmarshalExpect(t, &Setpoint{4, 13, 0xffff, []SetpointValue{
+		SetpointValue{Duration: 16, Setpoint: 8},
+		SetpointValue{Duration: 17, Setpoint: 95},
+		SetpointValue{Duration: 1000, Setpoint: 256},
+	}}, &Setpoint{})
+	testUnmarshalExpect(t, &Smooth{4, 20, []SetpointValue{
+		SetpointValue{Duration: 500, Setpoint: 1024},
+	}}, &Smooth{})
+	testUnmarshalExpect(t, &Smooth{4, 20, []SetpointValue{
+		SetpointValue{Duration: 500, Setpoint: 1024},
+		SetpointValue{Duration: 250, Setpoint: 0},
+		SetpointValue{Duration: 1000, Setpoint: 65535},
+	}}, &Smooth{})
+	testUnmarshalExpect(t, &Sleep{9}, &Sleep{})
+	testUnmarshalExpect(t, &Test{7}, &Test{})
+	testUnmarshalExpect(t, &Value{1}, &Value{})
+	testUnmarshalExpect(t, &Pong{'r'}, &Pong{})
+	testUnmarshalExpect(t, &Reading{1, 26075}, &Reading{})
+}
+
+func TestUnmarshal(t *testing.T) {
+	for _, m := range []Message{&Ping{'r'}, &Pong{'r'}, &Value{'s'}, &Reading{'s', 1000}} {
+		data, err := m.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if subject, err := Unmarshal(data); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(m, subject) {
+			t.Fatalf("Expected %v, got %v", m, subject)
+		}
+	}
+}
+
+func TestUnmarshalBinaryErrors(t *testing.T) {
+	ping := []byte{'r', '?', 0, 0, 6, 51}
+
+	// short message
+	testUnmarshalError(t, &Ping{}, ping[:5], InvalidLengthError)
+	// wrong message type
+	testUnmarshalError(t, &Sleep{}, ping, InvalidTypeError)
+	// bad checksum
+	testUnmarshalError(t, &Ping{}, []byte{'r', '?', 0, 0, 6, 52}, InvalidChecksumError)
+	// length field does not match data
+	testUnmarshalError(t, &Ping{}, append(ping, 0), InvalidLengthError)
+	// setpoint count does not match length field
+	testUnmarshalError(t, &Setpoint{}, []byte{
+		4, 'g', 10, 0,
+		13, 0, 255, 255, 2, 0,
+		16, 0, 8, 0,
+		95, 129,
+	}, InvalidLengthError)
+}
+
+func testUnmarshalExpect(t *testing.T, m encoding.BinaryMarshaler, subject encoding.BinaryUnmarshaler) {
+	data, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := subject.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, subject) {
+		t.Fatalf("Expected %v, got %v", m, subject)
+	}
+}
+
+func testUnmarshalError(t *testing.T, m encoding.BinaryUnmarshaler, data []byte, expect error) {
+	if err := m.UnmarshalBinary(data); err != expect {
+		t.Fatalf("Expected %v, got %v", expect, err)
+	}
+}
+
 func testMarshalExpect(t *testing.T, m encoding.BinaryMarshaler, expect []byte) {
 	subject, err := m.MarshalBinary()
 	if err != nil {
@@ -0,0 +1,220 @@
+package msgtype
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"sync"
+)
+
+// Maximum message data size accepted by the actuator boards.
+const MaxDataSize = 1024
+
+// A complete message as read from the wire, including the header and
+// checksum.
+type Frame []byte
+
+// Reader statistics.
+type ReaderStats struct {
+	Frames         uint64 `json:"frames"`          // frames read
+	Bytes          uint64 `json:"bytes"`           // bytes consumed
+	SkippedBytes   uint64 `json:"skipped_bytes"`   // bytes discarded to regain sync
+	ChecksumErrors uint64 `json:"checksum_errors"` // frames discarded with bad checksums
+}
+
+// Reader reads frames from a byte stream, such as a serial port. Bytes
+// that do not start a valid frame are discarded until sync is recovered.
+//
+// A frame is only checked once all the data its header claims has arrived,
+// so a corrupt header claiming setpoints or smooth motion, with up to 1022
+// bytes of data, holds back the frames after it until that many bytes have
+// been read. On a quiet line this blocks until more traffic arrives,
+// such as replies to position polling.
+type Reader struct {
+	r       *bufio.Reader
+	covered int // bytes left of the last frame with a bad checksum
+	mu      sync.Mutex
+	stats   ReaderStats
+}
+
+// Create a new reader.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, 6+MaxDataSize)}
+}
+
+// Read the next valid frame from the stream.
+func (r *Reader) ReadFrame() (Frame, error) {
+	for {
+		frame, ok, err := r.ReadAnyFrame()
+		if err != nil || ok {
+			return frame, err
+		}
+	}
+}
+
+// Read the next frame with a valid header and whether its checksum is valid,
+// for monitoring the line. Only the first byte of a frame with a bad
+// checksum is consumed, as by ReadFrame, so that a valid frame starting
+// inside it is still found; other frames with bad checksums starting inside
+// it are skipped rather than returned.
+func (r *Reader) ReadAnyFrame() (Frame, bool, error) {
+	for {
+		// read header
+		header, err := r.peek(4)
+		if err != nil {
+			return nil, false, err
+		}
+		size := int(binary.LittleEndian.Uint16(header[2:]))
+		if !validHeader(RemoteAddress(header[0]), header[1], size) {
+			r.skip(1, false)
+			continue
+		}
+
+		// read data and checksum
+		data, err := r.peek(6 + size)
+		if err != nil {
+			return nil, false, err
+		}
+		frame := make(Frame, len(data))
+		copy(frame, data)
+		if !validChecksum(data) {
+			if r.covered > 0 {
+				r.skip(1, false)
+				continue
+			}
+			r.skip(1, true)
+			r.covered = len(data) - 1
+			return frame, false, nil
+		}
+		r.r.Discard(len(data))
+		r.consumed(len(data))
+
+		r.mu.Lock()
+		r.stats.Frames++
+		r.stats.Bytes += uint64(len(data))
+		r.mu.Unlock()
+
+		return frame, true, nil
+	}
+}
+
+// Read and decode the next valid message from the stream.
+func (r *Reader) ReadMessage() (Message, error) {
+	frame, err := r.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	return frame.Message()
+}
+
+// Get reader statistics.
+func (r *Reader) Stats() ReaderStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+// Peek at the next n bytes, waiting for partial reads to complete.
+func (r *Reader) peek(n int) ([]byte, error) {
+	b, err := r.r.Peek(n)
+	if err == io.EOF && len(b) > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+// Discard bytes while searching for the next frame.
+func (r *Reader) skip(n int, checksum bool) {
+	r.r.Discard(n)
+	r.consumed(n)
+
+	r.mu.Lock()
+	r.stats.Bytes += uint64(n)
+	r.stats.SkippedBytes += uint64(n)
+	if checksum {
+		r.stats.ChecksumErrors++
+	}
+	r.mu.Unlock()
+}
+
+// Note bytes consumed, leaving any frame with a bad checksum.
+func (r *Reader) consumed(n int) {
+	if r.covered -= n; r.covered < 0 {
+		r.covered = 0
+	}
+}
+
+// Get the frame address, or InvalidAddress if the frame is too short.
+func (f Frame) Addr() RemoteAddress {
+	if len(f) < 1 {
+		return InvalidAddress
+	}
+	return RemoteAddress(f[0])
+}
+
+// Get the frame message type, or 0 if the frame is too short.
+func (f Frame) Type() uint8 {
+	if len(f) < 2 {
+		return 0
+	}
+	return f[1]
+}
+
+// Check the trailing checksum of the frame.
+func (f Frame) ValidChecksum() bool {
+	return len(f) >= 6 && validChecksum(f)
+}
+
+// Get the name of the frame message type, as given by NameOf, or the empty
+// string if unknown. The frame need not have a valid checksum.
+func (f Frame) TypeName() string {
+	switch f.Type() {
+	case kPing:
+		return CanPing
+	case kPong:
+		return "pong"
+	case kSetPID:
+		return CanSetPID
+	case kSetpoint:
+		return CanSetpoint
+	case kSmooth:
+		return CanSmooth
+	case kSleep:
+		return CanSleep
+	case kTest:
+		return CanTest
+	case kValue:
+		if len(f) == 8 {
+			return "reading"
+		}
+		return CanValue
+	}
+	return ""
+}
+
+// Decode the frame.
+func (f Frame) Message() (Message, error) {
+	return Unmarshal(f)
+}
+
+// Check that a header could start a frame of a known message type.
+func validHeader(addr RemoteAddress, msgtype uint8, size int) bool {
+	if addr == InvalidAddress || size > MaxDataSize {
+		return false
+	}
+
+	switch msgtype {
+	case kPing, kPong, kSleep, kTest:
+		return size == 0
+	case kValue:
+		return size == 0 || size == 2
+	case kSetPID:
+		return size == 12
+	case kSetpoint:
+		return size >= 10 && (size-6)%4 == 0
+	case kSmooth:
+		return size >= 6 && (size-2)%4 == 0
+	}
+
+	return false
+}
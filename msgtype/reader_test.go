@@ -0,0 +1,103 @@
+package msgtype
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReader(t *testing.T) {
+	ping := []byte{'r', '?', 0, 0, 6, 51}
+	setpoint, _ := (&Setpoint{4, 13, 0xffff, []SetpointValue{
+		SetpointValue{Duration: 16, Setpoint: 8},
+	}}).MarshalBinary()
+
+	var b bytes.Buffer
+	// leading garbage
+	b.Write([]byte{0, 'x', 0xff})
+	b.Write(ping)
+	// bad checksum
+	b.Write([]byte{'r', '?', 0, 0, 6, 52})
+	b.Write(setpoint)
+	// truncated frame
+	b.Write(ping[:3])
+
+	r := NewReader(iotest.OneByteReader(&b))
+
+	if m, err := r.ReadMessage(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m, &Ping{'r'}) {
+		t.Fatalf("Expected ping, got %v", m)
+	}
+
+	if m, err := r.ReadMessage(); err != nil {
+		t.Fatal(err)
+	} else if sp, ok := m.(*Setpoint); !ok || sp.Addr != 4 || len(sp.Setpoints) != 1 {
+		t.Fatalf("Expected setpoint, got %v", m)
+	}
+
+	if _, err := r.ReadFrame(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Expected %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+
+	stats := r.Stats()
+	if stats.Frames != 2 {
+		t.Fatalf("Expected 2 frames, got %d", stats.Frames)
+	}
+	if stats.ChecksumErrors != 1 {
+		t.Fatalf("Expected 1 checksum error, got %d", stats.ChecksumErrors)
+	}
+	if stats.SkippedBytes != 9 {
+		t.Fatalf("Expected 9 skipped bytes, got %d", stats.SkippedBytes)
+	}
+}
+
+func TestReaderAnyFrame(t *testing.T) {
+	var b bytes.Buffer
+	b.Write([]byte{'r', '?', 0, 0, 6, 52})
+	b.Write([]byte{'r', '?', 0, 0, 6, 51})
+
+	r := NewReader(&b)
+	if f, ok, err := r.ReadAnyFrame(); err != nil || ok || !bytes.Equal(f, []byte{'r', '?', 0, 0, 6, 52}) {
+		t.Fatalf("Expected frame with bad checksum, got %v %v %v", f, ok, err)
+	}
+	if f, ok, err := r.ReadAnyFrame(); err != nil || !ok || !f.ValidChecksum() || f.Addr() != 'r' {
+		t.Fatalf("Expected ping, got %v %v %v", f, ok, err)
+	}
+	if _, _, err := r.ReadAnyFrame(); err != io.EOF {
+		t.Fatalf("Expected %v, got %v", io.EOF, err)
+	}
+
+	// frames with bad checksums inside one already returned are skipped,
+	// but valid frames inside one are still found
+	b.Write([]byte{'r', '?', 0, 0, 'r', '?', 0, 0, 1, 2})
+	b.Write([]byte{'r', '?', 0, 0, 'r', '?', 0, 0, 6, 51})
+	r = NewReader(&b)
+	for i, expect := range []bool{false, false, true} {
+		if _, ok, err := r.ReadAnyFrame(); err != nil || ok != expect {
+			t.Fatalf("Frame %d: expected checksum ok %v, got %v %v", i, expect, ok, err)
+		}
+	}
+	if _, _, err := r.ReadAnyFrame(); err != io.EOF {
+		t.Fatalf("Expected %v, got %v", io.EOF, err)
+	}
+	if stats := r.Stats(); stats.ChecksumErrors != 2 || stats.Frames != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestShortFrame(t *testing.T) {
+	for _, f := range []Frame{nil, {'r'}} {
+		if f.ValidChecksum() || f.TypeName() != "" {
+			t.Fatalf("Unexpected frame %v", f)
+		}
+	}
+	if addr := Frame(nil).Addr(); addr != InvalidAddress {
+		t.Fatalf("Expected invalid address, got %v", addr)
+	}
+	if addr := (Frame{'r'}).Addr(); addr != RibsAddress {
+		t.Fatalf("Expected ribs, got %v", addr)
+	}
+}
@@ -0,0 +1,180 @@
+package msgtype
+
+import (
+	"encoding"
+	"fmt"
+	"sync"
+)
+
+// Actuator capabilities, named after the commands an actuator accepts.
+const (
+	CanPing     = "ping"
+	CanSetPID   = "setpid"
+	CanSetpoint = "setpoint"
+	CanSmooth   = "smooth"
+	CanSleep    = "sleep"
+	CanTest     = "test"
+	CanValue    = "value"
+)
+
+// All actuator capabilities.
+var Capabilities = []string{
+	CanPing,
+	CanSetPID,
+	CanSetpoint,
+	CanSmooth,
+	CanSleep,
+	CanTest,
+	CanValue,
+}
+
+// Description of an actuator board.
+type ActuatorInfo struct {
+	Name         string        `json:"name"`
+	Addr         RemoteAddress `json:"-"`
+	Description  string        `json:"description,omitempty"`
+	Capabilities []string      `json:"capabilities"`    // commands accepted, or nil for all
+	Range        [2]uint16     `json:"range"`           // positions reached, in (1 / 2^16) increments of a circle
+	Units        string        `json:"units,omitempty"` // default units of positions, or raw if empty
+	Offset       uint16        `json:"offset"`          // position of zero degrees
+	Reversed     bool          `json:"reversed"`        // angles increase towards lower positions
+}
+
+// Registered actuators, in order.
+var registry = struct {
+	sync.RWMutex
+	list []ActuatorInfo
+}{list: DefaultActuators()}
+
+// Get the actuators of the Cuddlebot.
+func DefaultActuators() []ActuatorInfo {
+	return []ActuatorInfo{
+		{RibsAddressString, RibsAddress, "ribs actuator", nil, [2]uint16{0, 0xffff}, "", 0, false},
+		{PurrAddressString, PurrAddress, "purr motor", nil, [2]uint16{0, 0xffff}, "", 0, false},
+		{SpineAddressString, SpineAddress, "spine actuator", nil, [2]uint16{0, 0xffff}, "", 0, false},
+		{HeadXAddressString, HeadXAddress, "head yaw actuator", nil, [2]uint16{0, 0xffff}, "", 0, false},
+		{HeadYAddressString, HeadYAddress, "head pitch actuator", nil, [2]uint16{0, 0xffff}, "", 0, false},
+	}
+}
+
+// Replace the registered actuators. Names and addresses must be unique.
+func SetActuators(list []ActuatorInfo) error {
+	if err := CheckActuators(list); err != nil {
+		return err
+	}
+
+	registry.Lock()
+	registry.list = append([]ActuatorInfo(nil), list...)
+	registry.Unlock()
+
+	return nil
+}
+
+// Check a list of actuators for missing or duplicate names and addresses,
+// and unknown capabilities or units.
+func CheckActuators(list []ActuatorInfo) error {
+	names := make(map[string]bool)
+	addrs := make(map[RemoteAddress]bool)
+	for _, a := range list {
+		if a.Name == "" || a.Addr == InvalidAddress {
+			return fmt.Errorf("actuator %q: missing name or address", a.Name)
+		} else if names[a.Name] {
+			return fmt.Errorf("actuator %q: duplicate name", a.Name)
+		} else if addrs[a.Addr] {
+			return fmt.Errorf("actuator %q: duplicate address %q", a.Name, rune(a.Addr))
+		} else if a.Range[0] > a.Range[1] {
+			return fmt.Errorf("actuator %q: range %d > %d", a.Name, a.Range[0], a.Range[1])
+		} else if a.Units != "" && !contains(Units, a.Units) {
+			return fmt.Errorf("actuator %q: unknown units %q", a.Name, a.Units)
+		}
+		for _, c := range a.Capabilities {
+			if !contains(Capabilities, c) {
+				return fmt.Errorf("actuator %q: unknown capability %q", a.Name, c)
+			}
+		}
+		names[a.Name] = true
+		addrs[a.Addr] = true
+	}
+	return nil
+}
+
+// Get the registered actuators.
+func Actuators() []ActuatorInfo {
+	registry.RLock()
+	defer registry.RUnlock()
+	return append([]ActuatorInfo(nil), registry.list...)
+}
+
+// Get the addresses of the registered actuators.
+func Addresses() []RemoteAddress {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	addrs := make([]RemoteAddress, len(registry.list))
+	for i, a := range registry.list {
+		addrs[i] = a.Addr
+	}
+	return addrs
+}
+
+// Find a registered actuator by address.
+func LookupAddress(addr RemoteAddress) (ActuatorInfo, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	for _, a := range registry.list {
+		if a.Addr == addr {
+			return a, true
+		}
+	}
+	return ActuatorInfo{}, false
+}
+
+// Find a registered actuator by name.
+func LookupName(name string) (ActuatorInfo, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	for _, a := range registry.list {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return ActuatorInfo{}, false
+}
+
+// Check whether the actuator has a capability.
+func (a ActuatorInfo) Can(capability string) bool {
+	return a.Capabilities == nil || contains(a.Capabilities, capability)
+}
+
+// Get the capability needed to send a message, or the empty string for
+// replies.
+func CapabilityOf(m encoding.BinaryMarshaler) string {
+	switch m.(type) {
+	case *Ping:
+		return CanPing
+	case *SetPID:
+		return CanSetPID
+	case *Setpoint:
+		return CanSetpoint
+	case *Smooth:
+		return CanSmooth
+	case *Sleep:
+		return CanSleep
+	case *Test:
+		return CanTest
+	case *Value:
+		return CanValue
+	}
+	return ""
+}
+
+func contains(list []string, s string) bool {
+	for _, t := range list {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package msgtype
+
+import (
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	defer SetActuators(DefaultActuators())
+
+	err := SetActuators(append(DefaultActuators(), ActuatorInfo{
+		Name:         "tail",
+		Addr:         't',
+		Capabilities: []string{CanSleep, CanSetpoint},
+		Range:        [2]uint16{16384, 49152},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testRemoteAddress(t, 't', "tail", "tail")
+	var addr RemoteAddress
+	if err := addr.UnmarshalText([]byte("tail")); err != nil || addr != 't' {
+		t.Fatalf("tail unmarshaled as %d %v", addr, err)
+	}
+	if len(Addresses()) != 6 {
+		t.Fatalf("expected 6 addresses, got %v", Addresses())
+	}
+
+	a, _ := LookupName("tail")
+	if !a.Can(CapabilityOf(&Setpoint{})) || a.Can(CapabilityOf(&SetPID{})) {
+		t.Fatalf("unexpected capabilities %v", a.Capabilities)
+	}
+	if a, _ := LookupName("ribs"); !a.Can(CanSetPID) {
+		t.Fatal("ribs should accept every command")
+	}
+
+	for _, list := range [][]ActuatorInfo{
+		{{Name: "tail"}},
+		{{Name: "tail", Addr: 't'}, {Name: "tail", Addr: 'u'}},
+		{{Name: "tail", Addr: 't'}, {Name: "paws", Addr: 't'}},
+		{{Name: "tail", Addr: 't', Capabilities: []string{"wag"}}},
+		{{Name: "tail", Addr: 't', Range: [2]uint16{2, 1}}},
+	} {
+		if err := SetActuators(list); err == nil {
+			t.Fatalf("expected error for %+v", list)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package msgtype
+
+import (
+	"errors"
+	"math"
+)
+
+// Position units.
+const (
+	UnitsRaw     = "raw"  // (1 / 2^16) increments of a circle, as sent to the board
+	UnitsDegrees = "deg"  // degrees from the zero offset
+	UnitsRadians = "rad"  // radians from the zero offset
+	UnitsNormal  = "norm" // 0..1 across the actuator range
+)
+
+// All position units.
+var Units = []string{UnitsRaw, UnitsDegrees, UnitsRadians, UnitsNormal}
+
+// Invalid units error.
+var InvalidUnitsError = errors.New("Invalid units")
+
+// Invalid position error.
+var InvalidPositionError = errors.New("Invalid position")
+
+// Invalid duration error.
+var InvalidDurationError = errors.New("Invalid duration")
+
+// Get the units to use for the actuator, which are the given units if any,
+// otherwise the default units of the actuator.
+func (a ActuatorInfo) UnitsOf(units string) string {
+	if units != "" {
+		return units
+	} else if a.Units != "" {
+		return a.Units
+	}
+	return UnitsRaw
+}
+
+// Convert a position in the given units to a setpoint. Angles are measured
+// from the zero offset in the direction of the actuator and wrap around the
+// circle; normalised positions must lie in 0..1.
+func (a ActuatorInfo) Encode(v float64, units string) (uint16, error) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, InvalidPositionError
+	}
+
+	var turns float64
+
+	switch a.UnitsOf(units) {
+	case UnitsRaw:
+		if v < 0 || v > 0xffff || v != math.Floor(v) {
+			return 0, InvalidPositionError
+		}
+		return uint16(v), nil
+	case UnitsNormal:
+		if v < 0 || v > 1 {
+			return 0, InvalidPositionError
+		}
+		if a.Reversed {
+			v = 1 - v
+		}
+		lo, hi := float64(a.Range[0]), float64(a.Range[1])
+		return uint16(math.Floor(lo + v*(hi-lo) + 0.5)), nil
+	case UnitsDegrees:
+		turns = v / 360
+	case UnitsRadians:
+		turns = v / (2 * math.Pi)
+	default:
+		return 0, InvalidUnitsError
+	}
+
+	if a.Reversed {
+		turns = -turns
+	}
+	p := math.Mod(math.Floor(float64(a.Offset)+turns*65536+0.5), 65536)
+	if p < 0 {
+		p += 65536
+	}
+	return uint16(p), nil
+}
+
+// Convert a position reading or setpoint to the given units. Angles lie in
+// -180..180 degrees, or -pi..pi radians, of the zero offset.
+func (a ActuatorInfo) Decode(p uint16, units string) (float64, error) {
+	units = a.UnitsOf(units)
+
+	switch units {
+	case UnitsRaw:
+		return float64(p), nil
+	case UnitsNormal:
+		lo, hi := float64(a.Range[0]), float64(a.Range[1])
+		if hi == lo {
+			return 0, nil
+		}
+		v := (float64(p) - lo) / (hi - lo)
+		if a.Reversed {
+			v = 1 - v
+		}
+		return v, nil
+	case UnitsDegrees, UnitsRadians:
+	default:
+		return 0, InvalidUnitsError
+	}
+
+	turns := float64(int16(p-a.Offset)) / 65536
+	if a.Reversed {
+		turns = -turns
+	}
+	if units == UnitsDegrees {
+		return turns * 360, nil
+	}
+	return turns * 2 * math.Pi, nil
+}
+
+// Convert a position in the given units to a setpoint for the registered
+// actuator at an address. Unregistered actuators take raw positions.
+func EncodePosition(addr RemoteAddress, v float64, units string) (uint16, error) {
+	a, _ := LookupAddress(addr)
+	return a.Encode(v, units)
+}
+
+// Convert a setpoint or reading to the given units for the registered
+// actuator at an address.
+func DecodePosition(addr RemoteAddress, p uint16, units string) (float64, error) {
+	a, _ := LookupAddress(addr)
+	return a.Decode(p, units)
+}
+
+// Convert pairs of durations in ms and positions in the given units to
+// setpoint values for the actuator at an address.
+func EncodeSetpoints(addr RemoteAddress, pairs []float64, units string) ([]SetpointValue, error) {
+	if len(pairs)%2 != 0 {
+		return nil, InvalidLengthError
+	}
+
+	a, _ := LookupAddress(addr)
+	values := make([]SetpointValue, len(pairs)/2)
+	for i := range values {
+		d := pairs[2*i]
+		if d < 0 || d > 0xffff || d != math.Floor(d) {
+			return nil, InvalidDurationError
+		}
+		p, err := a.Encode(pairs[2*i+1], units)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = SetpointValue{uint16(d), p}
+	}
+	return values, nil
+}
@@ -0,0 +1,87 @@
+package msgtype
+
+import (
+	"math"
+	"testing"
+)
+
+func TestUnits(t *testing.T) {
+	a := ActuatorInfo{Name: "tail", Addr: 't', Range: [2]uint16{16384, 49152}, Offset: 32768}
+	r := a
+	r.Reversed = true
+
+	for _, c := range []struct {
+		a     ActuatorInfo
+		v     float64
+		units string
+		p     uint16
+	}{
+		{a, 26075, "", 26075},
+		{a, 26075, UnitsRaw, 26075},
+		{a, 0, UnitsDegrees, 32768},
+		{a, 90, UnitsDegrees, 49152},
+		{a, -90, UnitsDegrees, 16384},
+		{a, 180, UnitsDegrees, 0},
+		{a, math.Pi / 2, UnitsRadians, 49152},
+		{r, 90, UnitsDegrees, 16384},
+		{a, 0, UnitsNormal, 16384},
+		{a, 0.5, UnitsNormal, 32768},
+		{r, 0.25, UnitsNormal, 40960},
+	} {
+		p, err := c.a.Encode(c.v, c.units)
+		if err != nil {
+			t.Fatalf("%g %s: %v", c.v, c.units, err)
+		} else if p != c.p {
+			t.Fatalf("%g %s: expected %d, got %d", c.v, c.units, c.p, p)
+		}
+		if c.v == 180 {
+			// decodes to -180
+			continue
+		}
+		if v, err := c.a.Decode(p, c.units); err != nil {
+			t.Fatal(err)
+		} else if math.Abs(v-c.v) > 1e-9 {
+			t.Fatalf("%d %s: expected %g, got %g", p, c.units, c.v, v)
+		}
+	}
+
+	// default units of the actuator
+	d := a
+	d.Units = UnitsDegrees
+	if p, _ := d.Encode(90, ""); p != 49152 {
+		t.Fatalf("expected degrees by default, got %d", p)
+	}
+
+	for _, c := range []struct {
+		v     float64
+		units string
+		err   error
+	}{
+		{-1, UnitsRaw, InvalidPositionError},
+		{65536, UnitsRaw, InvalidPositionError},
+		{1.5, UnitsRaw, InvalidPositionError},
+		{1.5, UnitsNormal, InvalidPositionError},
+		{math.NaN(), UnitsDegrees, InvalidPositionError},
+		{1, "furlongs", InvalidUnitsError},
+	} {
+		if _, err := a.Encode(c.v, c.units); err != c.err {
+			t.Fatalf("%g %s: expected %v, got %v", c.v, c.units, c.err, err)
+		}
+	}
+}
+
+func TestEncodeSetpoints(t *testing.T) {
+	values, err := EncodeSetpoints(RibsAddress, []float64{1000, 26075, 0xffff, 0}, "")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(values) != 2 || values[0] != (SetpointValue{1000, 26075}) || values[1] != (SetpointValue{0xffff, 0}) {
+		t.Fatalf("unexpected setpoints %v", values)
+	}
+
+	if _, err := EncodeSetpoints(RibsAddress, []float64{1000}, ""); err != InvalidLengthError {
+		t.Fatalf("expected %v, got %v", InvalidLengthError, err)
+	}
+	if _, err := EncodeSetpoints(RibsAddress, []float64{-1, 0}, ""); err != InvalidDurationError {
+		t.Fatalf("expected %v, got %v", InvalidDurationError, err)
+	}
+}
@@ -0,0 +1,151 @@
+package sim
+
+import (
+	"math"
+	"time"
+
+	"../msgtype"
+)
+
+// Integration step.
+const step = time.Millisecond
+
+// Maximum speed in (1 / 2^16) increments of a circle per second.
+const MaxSpeed = 1 << 15
+
+// Actuator models a single actuator board with a PID-controlled motor.
+type Actuator struct {
+	Addr     msgtype.RemoteAddress `json:"addr"`
+	Kp       float32               `json:"kp"`
+	Ki       float32               `json:"ki"`
+	Kd       float32               `json:"kd"`
+	Position float64               `json:"position"` // in (1 / 2^16) increments of a circle
+	Target   float64               `json:"target"`   // current setpoint
+	Sleeping bool                  `json:"sleeping"` // motor output disabled
+
+	program   *msgtype.Setpoint // active setpoints
+	smooth    *msgtype.Smooth   // active smooth motion
+	from      float64           // position at start of smooth motion
+	started   time.Duration     // time program or smooth motion started
+	integral  float64
+	lastError float64
+}
+
+// Create an actuator with default PID coefficients, asleep at position 0.
+func NewActuator(addr msgtype.RemoteAddress) *Actuator {
+	return &Actuator{
+		Addr:     addr,
+		Kp:       10,
+		Sleeping: true,
+	}
+}
+
+// Apply a message received at the given time, returning the reply if any.
+func (a *Actuator) handle(m msgtype.Message, now time.Duration) msgtype.Message {
+	switch m := m.(type) {
+	case *msgtype.Ping:
+		return &msgtype.Pong{a.Addr}
+	case *msgtype.Value:
+		return &msgtype.Reading{a.Addr, a.reading()}
+	case *msgtype.SetPID:
+		a.Kp, a.Ki, a.Kd = m.Kp, m.Ki, m.Kd
+		a.integral = 0
+	case *msgtype.Setpoint:
+		a.program = m
+		a.smooth = nil
+		a.started = now
+		a.wake()
+	case *msgtype.Smooth:
+		if len(m.Setpoint) == 0 {
+			return nil
+		}
+		a.program = nil
+		a.smooth = m
+		a.from = a.Position
+		a.started = now
+		a.wake()
+	case *msgtype.Sleep:
+		a.program = nil
+		a.smooth = nil
+		a.Sleeping = true
+	}
+	return nil
+}
+
+// Enable motor output, holding the current position.
+func (a *Actuator) wake() {
+	if a.Sleeping {
+		a.Sleeping = false
+		a.Target = a.Position
+		a.integral = 0
+		a.lastError = 0
+	}
+}
+
+// Advance the model by one integration step ending at the given time.
+func (a *Actuator) step(now time.Duration) {
+	if a.Sleeping {
+		return
+	}
+
+	a.Target = a.target(now)
+
+	// PID control with the error in circles
+	dt := step.Seconds()
+	e := (a.Target - a.Position) / 65536
+	a.integral = clamp(a.integral+e*dt, -1, 1)
+	d := (e - a.lastError) / dt
+	a.lastError = e
+
+	u := float64(a.Kp)*e + float64(a.Ki)*a.integral + float64(a.Kd)*d
+	v := clamp(u*65536, -MaxSpeed, MaxSpeed)
+
+	a.Position += v * dt
+}
+
+// Get the setpoint at the given time.
+func (a *Actuator) target(now time.Duration) float64 {
+	elapsed := now - a.started
+
+	if a.program != nil {
+		if v, ok := a.program.ValueAt(elapsed); ok {
+			return float64(v)
+		}
+		return a.Target
+	}
+
+	if a.smooth != nil {
+		// move towards each target in turn, updating the setpoint every
+		// interval if one is given
+		if a.smooth.Time > 0 {
+			interval := time.Duration(a.smooth.Time) * time.Millisecond
+			elapsed -= elapsed % interval
+		}
+		from := a.from
+		for _, sp := range a.smooth.Setpoint {
+			to := float64(sp.Setpoint)
+			d := time.Duration(sp.Duration) * time.Millisecond
+			if elapsed < d {
+				return from + (to-from)*float64(elapsed)/float64(d)
+			}
+			elapsed -= d
+			from = to
+		}
+		return from
+	}
+
+	return a.Target
+}
+
+// Get the position as reported by the encoder.
+func (a *Actuator) reading() uint16 {
+	p := math.Mod(math.Floor(a.Position+0.5), 65536)
+	if p < 0 {
+		p += 65536
+	}
+	return uint16(p)
+}
+
+func clamp(x, min, max float64) float64 {
+	return math.Max(min, math.Min(max, x))
+}
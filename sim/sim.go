@@ -0,0 +1,133 @@
+/*
+
+Package sim simulates the Cuddlebot actuator boards, speaking the same wire
+protocol as the robot so that cuddled and cuddlespeak can be run without
+hardware.
+
+*/
+package sim
+
+import (
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"../msgtype"
+)
+
+// Simulator models a set of actuators sharing a serial connection.
+type Simulator struct {
+	// Print each message received.
+	Debug bool
+	// Clock used to advance the model.
+	Now func() time.Time
+
+	mu        sync.Mutex
+	actuators map[msgtype.RemoteAddress]*Actuator
+	last      time.Time     // time the model was last advanced
+	elapsed   time.Duration // simulated time
+	remainder time.Duration // time not yet simulated
+}
+
+var simOut = log.New(os.Stdout, "[sim] ", 0)
+var simErr = log.New(os.Stderr, "[sim] ", 0)
+
+// Create a simulator with an actuator for every registered address.
+func New() *Simulator {
+	s := &Simulator{
+		Now:       time.Now,
+		actuators: make(map[msgtype.RemoteAddress]*Actuator),
+	}
+	for _, addr := range msgtype.Addresses() {
+		s.actuators[addr] = NewActuator(addr)
+	}
+	return s
+}
+
+// Read messages from the connection and write replies until reading fails.
+func (s *Simulator) Serve(rw io.ReadWriter) error {
+	r := msgtype.NewReader(rw)
+	for {
+		frame, err := r.ReadFrame()
+		if err != nil {
+			return err
+		}
+
+		m, err := frame.Message()
+		if err != nil {
+			simErr.Printf("Failed to decode message %s %x", err.Error(), []byte(frame))
+			continue
+		}
+		if s.Debug {
+			simOut.Printf("Received %T %+v", m, m)
+		}
+
+		reply := s.Handle(m)
+		if reply == nil {
+			continue
+		}
+		if buf, err := reply.MarshalBinary(); err != nil {
+			simErr.Printf("Failed to marshal reply %s %+v", err.Error(), reply)
+		} else if _, err := rw.Write(buf); err != nil {
+			return err
+		}
+	}
+}
+
+// Apply a message to the addressed actuator, returning the reply if any.
+// Messages for unknown addresses are ignored, as on the robot.
+func (s *Simulator) Handle(m msgtype.Message) msgtype.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.advance()
+
+	a, ok := s.actuators[msgtype.AddressOf(m)]
+	if !ok {
+		return nil
+	}
+	return a.handle(m, s.elapsed)
+}
+
+// Advance the model by the given time.
+func (s *Simulator) Step(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.step(d)
+}
+
+// Get a copy of the actuator state at an address.
+func (s *Simulator) Actuator(addr msgtype.RemoteAddress) (Actuator, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.advance()
+
+	a, ok := s.actuators[addr]
+	if !ok {
+		return Actuator{}, false
+	}
+	return *a, true
+}
+
+// Advance the model to the current time.
+func (s *Simulator) advance() {
+	now := s.Now()
+	if !s.last.IsZero() {
+		s.step(now.Sub(s.last))
+	}
+	s.last = now
+}
+
+func (s *Simulator) step(d time.Duration) {
+	s.remainder += d
+	for ; s.remainder >= step; s.remainder -= step {
+		s.elapsed += step
+		for _, a := range s.actuators {
+			a.step(s.elapsed)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"../msgtype"
+)
+
+func newTestSimulator() *Simulator {
+	s := New()
+	now := time.Now()
+	s.Now = func() time.Time { return now }
+	return s
+}
+
+func TestReplies(t *testing.T) {
+	s := newTestSimulator()
+
+	if reply := s.Handle(&msgtype.Ping{msgtype.RibsAddress}); reply == nil {
+		t.Fatal("No reply to ping")
+	} else if pong, ok := reply.(*msgtype.Pong); !ok || pong.Addr != msgtype.RibsAddress {
+		t.Fatalf("Expected pong from ribs, got %v", reply)
+	}
+
+	if reply := s.Handle(&msgtype.Value{msgtype.SpineAddress}); reply == nil {
+		t.Fatal("No reply to value")
+	} else if reading, ok := reply.(*msgtype.Reading); !ok || reading.Addr != msgtype.SpineAddress {
+		t.Fatalf("Expected reading from spine, got %v", reply)
+	}
+
+	if reply := s.Handle(&msgtype.Ping{'q'}); reply != nil {
+		t.Fatalf("Unknown address replied with %v", reply)
+	}
+}
+
+func TestSetpoint(t *testing.T) {
+	s := newTestSimulator()
+	s.Handle(&msgtype.Setpoint{msgtype.HeadXAddress, 100, 0, []msgtype.SetpointValue{
+		msgtype.SetpointValue{Duration: 1000, Setpoint: 4096},
+	}})
+
+	// hold position during delay
+	s.Step(99 * time.Millisecond)
+	if a, _ := s.Actuator(msgtype.HeadXAddress); a.Position != 0 || a.Sleeping {
+		t.Fatalf("Expected awake at 0, got %+v", a)
+	}
+
+	// converge on setpoint
+	s.Step(time.Second)
+	if a, _ := s.Actuator(msgtype.HeadXAddress); a.Target != 4096 || a.reading() < 4000 || a.reading() > 4096 {
+		t.Fatalf("Expected position near 4096, got %+v", a)
+	}
+
+	// sleep holds position
+	s.Handle(&msgtype.Sleep{msgtype.HeadXAddress})
+	before, _ := s.Actuator(msgtype.HeadXAddress)
+	s.Step(time.Second)
+	if a, _ := s.Actuator(msgtype.HeadXAddress); !a.Sleeping || a.Position != before.Position {
+		t.Fatalf("Expected asleep at %f, got %+v", before.Position, a)
+	}
+}
+
+func TestSmooth(t *testing.T) {
+	s := newTestSimulator()
+	s.Handle(&msgtype.Smooth{msgtype.SpineAddress, 0, []msgtype.SetpointValue{
+		msgtype.SetpointValue{Duration: 1000, Setpoint: 2000},
+	}})
+
+	s.Step(500 * time.Millisecond)
+	if a, _ := s.Actuator(msgtype.SpineAddress); a.Target != 1000 {
+		t.Fatalf("Expected target 1000 half way, got %+v", a)
+	}
+	s.Step(time.Second)
+	if a, _ := s.Actuator(msgtype.SpineAddress); a.Target != 2000 {
+		t.Fatalf("Expected target 2000, got %+v", a)
+	}
+}
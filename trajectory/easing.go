@@ -0,0 +1,49 @@
+package trajectory
+
+import (
+	"math"
+)
+
+// An easing curve maps progress through a move, from 0 to 1, to the
+// fraction of the distance covered.
+type Easing func(x float64) float64
+
+// Easing curves.
+var (
+	EaseLinear      Easing = func(x float64) float64 { return x }
+	EaseInQuad      Easing = func(x float64) float64 { return x * x }
+	EaseOutQuad     Easing = func(x float64) float64 { return x * (2 - x) }
+	EaseInOutQuad   Easing = easeInOutQuad
+	EaseInCubic     Easing = func(x float64) float64 { return x * x * x }
+	EaseOutCubic    Easing = func(x float64) float64 { return 1 - (1-x)*(1-x)*(1-x) }
+	EaseInOutCubic  Easing = easeInOutCubic
+	EaseInOutSine   Easing = func(x float64) float64 { return (1 - math.Cos(math.Pi*x)) / 2 }
+	EaseMinimumJerk Easing = func(x float64) float64 { return x * x * x * (10 - 15*x + 6*x*x) }
+)
+
+// Easing curves by name.
+var Easings = map[string]Easing{
+	"linear":       EaseLinear,
+	"in-quad":      EaseInQuad,
+	"out-quad":     EaseOutQuad,
+	"in-out-quad":  EaseInOutQuad,
+	"in-cubic":     EaseInCubic,
+	"out-cubic":    EaseOutCubic,
+	"in-out-cubic": EaseInOutCubic,
+	"in-out-sine":  EaseInOutSine,
+	"minjerk":      EaseMinimumJerk,
+}
+
+func easeInOutQuad(x float64) float64 {
+	if x < 0.5 {
+		return 2 * x * x
+	}
+	return 1 - 2*(1-x)*(1-x)
+}
+
+func easeInOutCubic(x float64) float64 {
+	if x < 0.5 {
+		return 4 * x * x * x
+	}
+	return 1 - 4*(1-x)*(1-x)*(1-x)
+}
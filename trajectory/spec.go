@@ -0,0 +1,140 @@
+package trajectory
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Most samples taken of one trajectory.
+const MaxSamples = 0xffff
+
+// Longest trajectory that can be sampled, at the coarsest resolution.
+const MaxDuration = MaxSamples * 0xffff * time.Millisecond
+
+// Trajectory types.
+const (
+	TypeLinear  = "linear"  // ramp from one position to another
+	TypeMinJerk = "minjerk" // minimum-jerk move from one position to another
+	TypeSine    = "sine"    // sine wave about a centre position
+	TypeBreathe = "breathe" // breathing wave about a centre position
+	TypeSpline  = "spline"  // cubic spline through keyframes
+)
+
+// All trajectory types.
+var Types = []string{TypeLinear, TypeMinJerk, TypeSine, TypeBreathe, TypeSpline}
+
+// Invalid resolution error.
+var InvalidResolutionError = errors.New("Invalid resolution")
+
+// Too many samples error.
+var TooManySamplesError = errors.New("Too many samples")
+
+// Error in a field of a trajectory specification.
+type FieldError struct {
+	Field   string // name of the field in JSON
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// Specification of a trajectory, as given in requests. Times are in ms and
+// positions in the units of the request.
+type Spec struct {
+	Type      string       `json:"type"`
+	From      *float64     `json:"from,omitempty"`      // linear, minjerk
+	To        *float64     `json:"to,omitempty"`        // linear, minjerk
+	Duration  float64      `json:"duration,omitempty"`  // linear, minjerk
+	Ease      string       `json:"ease,omitempty"`      // linear: name of an easing curve
+	Center    float64      `json:"center,omitempty"`    // sine, breathe
+	Amplitude float64      `json:"amplitude,omitempty"` // sine, breathe
+	Period    float64      `json:"period,omitempty"`    // sine
+	Rate      float64      `json:"rate,omitempty"`      // breathe: breaths per minute
+	Cycles    int          `json:"cycles,omitempty"`    // sine, breathe: or 0 to repeat forever
+	Keyframes [][2]float64 `json:"keyframes,omitempty"` // spline: time and position pairs
+}
+
+// Build the trajectory. Waves repeated forever are built with one cycle,
+// to be looped.
+func (s *Spec) Trajectory() (Trajectory, error) {
+	switch s.Type {
+	case TypeLinear, TypeMinJerk:
+		if s.From == nil {
+			return nil, &FieldError{"from", "missing"}
+		} else if s.To == nil {
+			return nil, &FieldError{"to", "missing"}
+		} else if err := checkTime("duration", s.Duration); err != nil {
+			return nil, err
+		}
+		r := &Ramp{*s.From, *s.To, milliseconds(s.Duration), nil}
+		if s.Type == TypeMinJerk {
+			r.Ease = EaseMinimumJerk
+		} else if s.Ease != "" {
+			if r.Ease = Easings[s.Ease]; r.Ease == nil {
+				return nil, &FieldError{"ease", fmt.Sprintf("unknown easing curve %q", s.Ease)}
+			}
+		}
+		return r, nil
+
+	case TypeSine, TypeBreathe:
+		if s.Cycles < 0 {
+			return nil, &FieldError{"cycles", "must not be negative"}
+		}
+		cycles := s.Cycles
+		if cycles == 0 {
+			cycles = 1
+		}
+		if s.Type == TypeBreathe {
+			if s.Rate <= 0 || s.Rate > 600 {
+				return nil, &FieldError{"rate", "must be between 0 and 600 breaths per minute"}
+			}
+			return checkCycles(Breathe(s.Center, s.Amplitude, s.Rate, cycles))
+		}
+		if err := checkTime("period", s.Period); err != nil {
+			return nil, err
+		}
+		return checkCycles(Sine(s.Center, s.Amplitude, milliseconds(s.Period), cycles))
+
+	case TypeSpline:
+		if len(s.Keyframes) < 2 {
+			return nil, &FieldError{"keyframes", "at least two keyframes are needed"}
+		}
+		keyframes := make([]Keyframe, len(s.Keyframes))
+		for i, k := range s.Keyframes {
+			keyframes[i] = Keyframe{milliseconds(k[0]), k[1]}
+			if (i == 0 && k[0] != 0) || (i > 0 && keyframes[i].Time <= keyframes[i-1].Time) {
+				return nil, &FieldError{"keyframes", "times must increase from zero"}
+			}
+		}
+		return NewSpline(keyframes), nil
+
+	case "":
+		return nil, &FieldError{"type", "missing"}
+	}
+	return nil, &FieldError{"type", fmt.Sprintf("unknown trajectory type %q", s.Type)}
+}
+
+// Check whether the trajectory repeats forever.
+func (s *Spec) Forever() bool {
+	return (s.Type == TypeSine || s.Type == TypeBreathe) && s.Cycles == 0
+}
+
+func checkTime(field string, ms float64) error {
+	if ms <= 0 || ms > float64(time.Hour/time.Millisecond) {
+		return &FieldError{field, "must be between 0 and one hour in ms"}
+	}
+	return nil
+}
+
+func checkCycles(w *Wave) (Trajectory, error) {
+	if most := int(MaxDuration / w.Period); w.Cycles > most {
+		return nil, &FieldError{"cycles", fmt.Sprintf("must be at most %d for this period", most)}
+	}
+	return w, nil
+}
+
+func milliseconds(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
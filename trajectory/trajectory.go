@@ -0,0 +1,307 @@
+/*
+Package trajectory generates setpoints for the Cuddlebot actuators from
+ramps, waves and splines through keyframes.
+*/
+package trajectory
+
+import (
+	"math"
+	"time"
+
+	"../msgtype"
+)
+
+// Default time between samples.
+const DefaultResolution = 50 * time.Millisecond
+
+// A trajectory gives the position of an actuator over time.
+type Trajectory interface {
+	// Get the running time.
+	Duration() time.Duration
+	// Get the position at a time from the start.
+	At(t time.Duration) float64
+}
+
+// Move from one position to another, following an easing curve.
+type Ramp struct {
+	From, To float64
+	Time     time.Duration
+	Ease     Easing // shape of the move, or nil for linear
+}
+
+// Oscillate about a centre position.
+type Wave struct {
+	Center    float64
+	Amplitude float64
+	Period    time.Duration
+	Cycles    int     // number of periods
+	Phase     float64 // fraction of a period at the start
+	Rise      float64 // fraction of a period spent rising, or 0 for half
+	Shape     Easing  // shape of the rise and fall, or nil for a sine wave
+}
+
+// Pass through keyframes on a natural cubic spline.
+type Spline struct {
+	Keyframes []Keyframe // in order of time, starting at zero
+	m         []float64  // second derivatives at the keyframes
+}
+
+// A position at a time.
+type Keyframe struct {
+	Time     time.Duration
+	Position float64
+}
+
+// Trajectories followed one after another.
+type Sequence []Trajectory
+
+// A trajectory repeated for a running time.
+type Repeat struct {
+	Trajectory
+	Time time.Duration
+}
+
+// A weighted average of trajectories, each holding its final position once
+// it ends.
+type Blend struct {
+	Parts   []Trajectory
+	Weights []float64
+}
+
+// Create a linear ramp.
+func Linear(from, to float64, d time.Duration) *Ramp {
+	return &Ramp{from, to, d, nil}
+}
+
+// Create a minimum-jerk move, which starts and ends at rest.
+func MinimumJerk(from, to float64, d time.Duration) *Ramp {
+	return &Ramp{from, to, d, EaseMinimumJerk}
+}
+
+// Create a sine wave.
+func Sine(center, amplitude float64, period time.Duration, cycles int) *Wave {
+	return &Wave{center, amplitude, period, cycles, 0, 0, nil}
+}
+
+// Create a breathing wave at a rate in breaths per minute. Each breath rises
+// from the bottom over 40% of the period and falls more slowly, easing in
+// and out at both ends.
+func Breathe(center, amplitude, bpm float64, cycles int) *Wave {
+	period := time.Duration(float64(time.Minute) / bpm)
+	return &Wave{center, amplitude, period, cycles, 0, 0.4, EaseInOutSine}
+}
+
+// Create a natural cubic spline through keyframes.
+func NewSpline(keyframes []Keyframe) *Spline {
+	s := &Spline{Keyframes: keyframes}
+	s.solve()
+	return s
+}
+
+func (r *Ramp) Duration() time.Duration {
+	return r.Time
+}
+
+func (r *Ramp) At(t time.Duration) float64 {
+	if t >= r.Time || r.Time <= 0 {
+		return r.To
+	} else if t <= 0 {
+		return r.From
+	}
+	x := float64(t) / float64(r.Time)
+	if r.Ease != nil {
+		x = r.Ease(x)
+	}
+	return r.From + (r.To-r.From)*x
+}
+
+func (w *Wave) Duration() time.Duration {
+	return w.Period * time.Duration(w.Cycles)
+}
+
+func (w *Wave) At(t time.Duration) float64 {
+	if w.Period <= 0 {
+		return w.Center
+	}
+	x := math.Mod(float64(t)/float64(w.Period)+w.Phase, 1)
+	if x < 0 {
+		x++
+	}
+
+	if w.Shape == nil {
+		return w.Center + w.Amplitude*math.Sin(2*math.Pi*x)
+	}
+	// rise from the bottom, then fall
+	rise := w.Rise
+	if rise <= 0 || rise >= 1 {
+		rise = 0.5
+	}
+	if x < rise {
+		return w.Center + w.Amplitude*(2*w.Shape(x/rise)-1)
+	}
+	return w.Center + w.Amplitude*(1-2*w.Shape((x-rise)/(1-rise)))
+}
+
+func (s *Spline) Duration() time.Duration {
+	if len(s.Keyframes) == 0 {
+		return 0
+	}
+	return s.Keyframes[len(s.Keyframes)-1].Time
+}
+
+func (s *Spline) At(t time.Duration) float64 {
+	k := s.Keyframes
+	switch {
+	case len(k) == 0:
+		return 0
+	case t <= k[0].Time:
+		return k[0].Position
+	case t >= k[len(k)-1].Time:
+		return k[len(k)-1].Position
+	}
+
+	i := 1
+	for t > k[i].Time {
+		i++
+	}
+	h := float64(k[i].Time - k[i-1].Time)
+	a := float64(k[i].Time-t) / h
+	b := 1 - a
+	return a*k[i-1].Position + b*k[i].Position +
+		((a*a*a-a)*s.m[i-1]+(b*b*b-b)*s.m[i])*h*h/6
+}
+
+// Find the second derivatives of a natural spline, which are zero at the
+// ends, by solving the tridiagonal system.
+func (s *Spline) solve() {
+	k := s.Keyframes
+	n := len(k)
+	s.m = make([]float64, n)
+	if n < 3 {
+		return
+	}
+
+	c := make([]float64, n)
+	d := make([]float64, n)
+	for i := 1; i < n-1; i++ {
+		h0 := float64(k[i].Time - k[i-1].Time)
+		h1 := float64(k[i+1].Time - k[i].Time)
+		r := 6 * ((k[i+1].Position-k[i].Position)/h1 - (k[i].Position-k[i-1].Position)/h0)
+		b := 2 * (h0 + h1)
+		if i > 1 {
+			b -= h0 * c[i-1]
+			r -= h0 * d[i-1]
+		}
+		c[i] = h1 / b
+		d[i] = r / b
+	}
+	for i := n - 2; i > 0; i-- {
+		s.m[i] = d[i] - c[i]*s.m[i+1]
+	}
+}
+
+func (s Sequence) Duration() time.Duration {
+	var d time.Duration
+	for _, tr := range s {
+		d += tr.Duration()
+	}
+	return d
+}
+
+func (s Sequence) At(t time.Duration) float64 {
+	if len(s) == 0 {
+		return 0
+	}
+	for _, tr := range s {
+		if t < tr.Duration() {
+			return tr.At(t)
+		}
+		t -= tr.Duration()
+	}
+	last := s[len(s)-1]
+	return last.At(last.Duration())
+}
+
+func (r *Repeat) Duration() time.Duration {
+	return r.Time
+}
+
+func (r *Repeat) At(t time.Duration) float64 {
+	if t > r.Time {
+		t = r.Time
+	}
+	// each repetition ends at the end of the trajectory
+	if d := r.Trajectory.Duration(); d > 0 && t > 0 {
+		t = (t-1)%d + 1
+	}
+	return r.Trajectory.At(t)
+}
+
+func (b *Blend) Duration() time.Duration {
+	var d time.Duration
+	for _, tr := range b.Parts {
+		if tr.Duration() > d {
+			d = tr.Duration()
+		}
+	}
+	return d
+}
+
+func (b *Blend) At(t time.Duration) float64 {
+	var sum, total float64
+	for i, tr := range b.Parts {
+		at := t
+		if d := tr.Duration(); at > d {
+			at = d
+		}
+		sum += b.Weights[i] * tr.At(at)
+		total += b.Weights[i]
+	}
+	if total == 0 {
+		return 0
+	}
+	return sum / total
+}
+
+// Sample a trajectory every resolution, or DefaultResolution if zero, giving
+// setpoints for the actuator at an address in the given units. Each setpoint
+// lasts until the next sample and holds the position at its end, so the
+// last setpoint holds the final position.
+func Sample(tr Trajectory, resolution time.Duration, addr msgtype.RemoteAddress, units string) ([]msgtype.SetpointValue, error) {
+	if resolution <= 0 {
+		resolution = DefaultResolution
+	}
+	if resolution%time.Millisecond != 0 || resolution > 0xffff*time.Millisecond {
+		return nil, InvalidResolutionError
+	}
+
+	a, _ := msgtype.LookupAddress(addr)
+	raw := a.UnitsOf(units) == msgtype.UnitsRaw
+
+	total := tr.Duration()
+	var values []msgtype.SetpointValue
+	for t := time.Duration(0); t < total || len(values) == 0; t += resolution {
+		d := resolution
+		if t+d > total && total > t {
+			d = total - t
+		}
+		if len(values) >= MaxSamples {
+			return nil, TooManySamplesError
+		}
+
+		v := tr.At(t + d)
+		if raw {
+			v = math.Floor(v + 0.5)
+		}
+		p, err := a.Encode(v, units)
+		if err != nil {
+			return nil, err
+		}
+		ms := uint16((d + time.Millisecond/2) / time.Millisecond)
+		if ms == 0 {
+			ms = 1
+		}
+		values = append(values, msgtype.SetpointValue{ms, p})
+	}
+	return values, nil
+}
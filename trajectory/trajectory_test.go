@@ -0,0 +1,139 @@
+package trajectory
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"../msgtype"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestRamp(t *testing.T) {
+	r := MinimumJerk(0, 100, time.Second)
+	for _, c := range []struct {
+		t      time.Duration
+		expect float64
+	}{{-time.Second, 0}, {0, 0}, {500 * time.Millisecond, 50}, {time.Second, 100}, {2 * time.Second, 100}} {
+		if p := r.At(c.t); !near(p, c.expect) {
+			t.Errorf("At %v: expected %f, got %f", c.t, c.expect, p)
+		}
+	}
+	if p := r.At(100 * time.Millisecond); p >= 10 {
+		t.Errorf("Minimum-jerk move should start slowly, got %f at 10%%", p)
+	}
+}
+
+func TestWave(t *testing.T) {
+	w := Sine(10, 5, time.Second, 2)
+	if w.Duration() != 2*time.Second {
+		t.Fatalf("Expected 2s, got %v", w.Duration())
+	}
+	if p := w.At(250 * time.Millisecond); !near(p, 15) {
+		t.Errorf("Expected peak of 15, got %f", p)
+	}
+
+	// breathing rises over 40% of the period
+	b := Breathe(0, 20, 12, 1)
+	if b.Duration() != 5*time.Second {
+		t.Fatalf("Expected 5s, got %v", b.Duration())
+	}
+	for _, c := range []struct {
+		t      time.Duration
+		expect float64
+	}{{0, -20}, {2 * time.Second, 20}, {5 * time.Second, -20}} {
+		if p := b.At(c.t); !near(p, c.expect) {
+			t.Errorf("At %v: expected %f, got %f", c.t, c.expect, p)
+		}
+	}
+}
+
+func TestSpline(t *testing.T) {
+	keyframes := []Keyframe{{0, 0}, {time.Second, 10}, {2 * time.Second, 0}, {3 * time.Second, 5}}
+	s := NewSpline(keyframes)
+	for _, k := range keyframes {
+		if p := s.At(k.Time); !near(p, k.Position) {
+			t.Errorf("At %v: expected %f, got %f", k.Time, k.Position, p)
+		}
+	}
+	// curves between keyframes
+	if p := s.At(500 * time.Millisecond); p <= 5 {
+		t.Errorf("Expected curve above the straight line, got %f", p)
+	}
+}
+
+func TestRepeatAndBlend(t *testing.T) {
+	r := &Repeat{Linear(0, 10, time.Second), 3 * time.Second}
+	for _, c := range []struct {
+		t      time.Duration
+		expect float64
+	}{{0, 0}, {500 * time.Millisecond, 5}, {time.Second, 10}, {1500 * time.Millisecond, 5}, {4 * time.Second, 10}} {
+		if p := r.At(c.t); !near(p, c.expect) {
+			t.Errorf("Repeat at %v: expected %f, got %f", c.t, c.expect, p)
+		}
+	}
+
+	b := &Blend{[]Trajectory{Linear(0, 10, time.Second), Linear(20, 20, 2*time.Second)}, []float64{3, 1}}
+	if b.Duration() != 2*time.Second {
+		t.Fatalf("Expected 2s, got %v", b.Duration())
+	}
+	if p := b.At(1500 * time.Millisecond); !near(p, 12.5) {
+		t.Errorf("Blend: expected 12.5, got %f", p)
+	}
+}
+
+func TestSample(t *testing.T) {
+	values, err := Sample(Linear(0, 1000, 120*time.Millisecond), 50*time.Millisecond, 0, msgtype.UnitsRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []msgtype.SetpointValue{{50, 417}, {50, 833}, {20, 1000}}
+	if len(values) != len(expect) {
+		t.Fatalf("Expected %v, got %v", expect, values)
+	}
+	for i := range expect {
+		if values[i] != expect[i] {
+			t.Fatalf("Expected %v, got %v", expect, values)
+		}
+	}
+
+	if _, err := Sample(Linear(0, 1, time.Second), 1500*time.Microsecond, 0, msgtype.UnitsRaw); err != InvalidResolutionError {
+		t.Errorf("Expected %v, got %v", InvalidResolutionError, err)
+	}
+	if _, err := Sample(Linear(0, 1e6, time.Second), 0, 0, msgtype.UnitsRaw); err != msgtype.InvalidPositionError {
+		t.Errorf("Expected %v, got %v", msgtype.InvalidPositionError, err)
+	}
+}
+
+func TestSpec(t *testing.T) {
+	one, two := 1.0, 2.0
+	for _, s := range []Spec{
+		{Type: "linear", From: &one, To: &two, Duration: 100, Ease: "in-out-quad"},
+		{Type: "minjerk", From: &one, To: &two, Duration: 100},
+		{Type: "sine", Amplitude: 1, Period: 100},
+		{Type: "breathe", Amplitude: 20, Rate: 12},
+		{Type: "spline", Keyframes: [][2]float64{{0, 1}, {100, 2}, {200, 0}}},
+	} {
+		if _, err := s.Trajectory(); err != nil {
+			t.Errorf("%+v: %v", s, err)
+		}
+	}
+
+	for field, s := range map[string]Spec{
+		"type":      {Type: "zigzag"},
+		"to":        {Type: "linear", From: &one, Duration: 100},
+		"duration":  {Type: "linear", From: &one, To: &two},
+		"ease":      {Type: "linear", From: &one, To: &two, Duration: 100, Ease: "wobble"},
+		"rate":      {Type: "breathe", Amplitude: 20},
+		"cycles":    {Type: "sine", Amplitude: 1, Period: 3600000, Cycles: 3000000},
+		"keyframes": {Type: "spline", Keyframes: [][2]float64{{0, 1}, {0, 2}}},
+	} {
+		_, err := s.Trajectory()
+		if e, ok := err.(*FieldError); !ok || e.Field != field {
+			t.Errorf("%+v: expected error in %s, got %v", s, field, err)
+		}
+	}
+}